@@ -1,9 +1,11 @@
 package jobs
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"filesharing/models"
@@ -12,40 +14,149 @@ import (
 	"gorm.io/gorm"
 )
 
-func StartCleanupJob(db *gorm.DB) {
-	go func() {
-		for {
-			// Find expired files
-			var expiredFiles []models.File
-			if err := db.Where("expires_at < ?", time.Now()).Find(&expiredFiles).Error; err != nil {
-				log.Printf("Error finding expired files: %v", err)
-				time.Sleep(time.Hour)
-				continue
-			}
+// TypeCleanup is the scheduled job that warns owners about files nearing
+// their expiry and removes expired files from storage and the database.
+const TypeCleanup = "cleanup"
+
+// cleanupBatchSize bounds how many expired files one run removes
+const cleanupBatchSize = 500
+
+func init() {
+	Register(TypeCleanup, HandlerOptions{MaxAttempts: 1, Exclusive: true}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		if err := warnExpiringFiles(ctx, db, utils.EnvDuration("EXPIRY_WARNING", 24*time.Hour)); err != nil {
+			log.Printf("Error sending expiry warnings: %v", err)
+		}
+		return cleanupExpiredFiles(ctx, db, cache)
+	})
+}
+
+func cleanupExpiredFiles(ctx context.Context, db *gorm.DB, cache utils.Cache) error {
+	// Find expired files
+	var expiredFiles []models.File
+	if err := db.Where("expires_at <= ?", time.Now()).Order("expires_at").Limit(cleanupBatchSize).Find(&expiredFiles).Error; err != nil {
+		return err
+	}
+	if len(expiredFiles) == 0 {
+		return nil
+	}
+
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return err
+	}
+
+	// Delete expired files through the storage backend
+	failed := 0
+	for i := range expiredFiles {
+		// Stop if the job's lease was lost
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		file := &expiredFiles[i]
+		if err := PurgeFile(ctx, db, s3Client, file); err != nil {
+			log.Printf("Error deleting expired file %d: %v", file.ID, err)
+			failed++
+			continue
+		}
+
+		// Invalidate the owner's file cache
+		utils.InvalidateFileListings(ctx, cache, file.UserID)
+		EmitEvent(db, file.UserID, models.EventFileDeleted, map[string]interface{}{
+			"file_id":       file.ID,
+			"folder_id":     file.FolderID,
+			"original_name": file.OriginalName,
+			"size":          file.Size,
+			"mime_type":     file.MimeType,
+			"sha256":        file.ChecksumSHA256,
+			"reason":        "expired",
+		})
+
+		Notify(db, models.Notification{
+			UserID: file.UserID,
+			Type:   models.NotifyFileExpired,
+			Title:  file.OriginalName + " expired and was deleted",
+			Data:   map[string]interface{}{"file_id": file.ID},
+		})
+
+		log.Printf("Deleted expired file: %s", file.OriginalName)
+	}
 
-			// Delete expired files
-			for _, file := range expiredFiles {
-				// Delete physical file
-				filepath := filepath.Join("uploads", file.Filename)
-				if err := os.Remove(filepath); err != nil {
-					log.Printf("Error deleting expired file %s: %v", file.Filename, err)
-					continue
-				}
-
-				// Delete database record
-				if err := db.Delete(&file).Error; err != nil {
-					log.Printf("Error deleting expired file record %d: %v", file.ID, err)
-					continue
-				}
-
-				// Invalidate user's file cache
-				utils.ClearUserCache(file.UserID)
-
-				log.Printf("Deleted expired file: %s", file.OriginalName)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d expired files could not be deleted", failed, len(expiredFiles))
+	}
+	return nil
+}
+
+// warnExpiringFiles notifies each owner once about their files that expire
+// within the warning window, by email unless they turned that off. Changing
+// a file's expiry resets its warning.
+func warnExpiringFiles(ctx context.Context, db *gorm.DB, window time.Duration) error {
+	if window <= 0 {
+		return nil
+	}
+	now := time.Now()
+
+	var files []models.File
+	if err := db.Preload("User").
+		Where("expires_at > ? AND expires_at <= ? AND expiry_warned_at IS NULL", now, now.Add(window)).
+		Order("user_id, expires_at").Limit(cleanupBatchSize).Find(&files).Error; err != nil {
+		return err
+	}
+
+	byUser := map[uint][]models.File{}
+	var order []uint
+	for _, file := range files {
+		if _, ok := byUser[file.UserID]; !ok {
+			order = append(order, file.UserID)
+		}
+		byUser[file.UserID] = append(byUser[file.UserID], file)
+	}
+
+	for _, userID := range order {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		userFiles := byUser[userID]
+		owner := userFiles[0].User
+		if owner == nil {
+			continue
+		}
+
+		var body strings.Builder
+		fmt.Fprintf(&body, "Hi %s,\n\nThe following files will be deleted soon:\n\n", owner.Username)
+		ids := make([]uint, 0, len(userFiles))
+		for _, file := range userFiles {
+			fmt.Fprintf(&body, "  %s (expires %s)\n", file.OriginalName, file.ExpiresAt.Format(time.RFC1123))
+			ids = append(ids, file.ID)
+		}
+		body.WriteString("\nExtend or remove their expiry to keep them.\n")
+
+		// A warning whose email fails is still shown in the app and goes out
+		// with the next digest instead
+		var emailedAt *time.Time
+		if notificationPreference(db, userID, models.NotifyFileExpiring).Email {
+			subject := fmt.Sprintf("%d file(s) expiring soon", len(userFiles))
+			if err := mailer.Send(owner.Email, subject, body.String()); err != nil {
+				log.Printf("Error sending expiry warning to user %d: %v", userID, err)
+			} else {
+				emailedAt = &now
 			}
+		}
+		for _, file := range userFiles {
+			Notify(db, models.Notification{
+				UserID:    userID,
+				Type:      models.NotifyFileExpiring,
+				Title:     file.OriginalName + " expires soon",
+				Body:      "Expires " + file.ExpiresAt.Format(time.RFC1123) + ". Extend or remove its expiry to keep it.",
+				Data:      map[string]interface{}{"file_id": file.ID, "expires_at": file.ExpiresAt},
+				EmailedAt: emailedAt,
+			})
+		}
 
-			// Sleep for an hour before next cleanup
-			time.Sleep(time.Hour)
+		if err := db.Model(&models.File{}).Where("id IN ?", ids).Update("expiry_warned_at", now).Error; err != nil {
+			log.Printf("Error recording expiry warning for user %d: %v", userID, err)
 		}
-	}()
+	}
+	return nil
 }
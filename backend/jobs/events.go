@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// eventBus carries events to the live streams; nil until SetEventBus
+var eventBus utils.EventBus
+
+// SetEventBus sets the bus EmitEvent publishes live change events on
+func SetEventBus(bus utils.EventBus) {
+	eventBus = bus
+}
+
+// EmitEvent publishes an event on the user's live event stream and queues
+// its delivery to every active webhook of the user that subscribes to it.
+// Failures are logged; they never fail the caller.
+func EmitEvent(db *gorm.DB, userID uint, eventType string, data interface{}) {
+	publishEvent(userID, eventType, data)
+
+	var hooks []models.Webhook
+	if err := db.Where("user_id = ? AND active", userID).Find(&hooks).Error; err != nil {
+		log.Printf("Error loading webhooks for user %d: %v", userID, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	event, err := json.Marshal(NewWebhookEvent(userID, eventType, data))
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+	for _, hook := range hooks {
+		if !WebhookMatches(hook.Events, eventType) {
+			continue
+		}
+		if _, err := Enqueue(db, TypeWebhook, webhookPayload{WebhookID: hook.ID, Event: event}); err != nil {
+			log.Printf("Error queueing %s for webhook %d: %v", eventType, hook.ID, err)
+		}
+	}
+}
+
+// publishEvent sends an event to the user's live event stream only
+func publishEvent(userID uint, eventType string, data interface{}) {
+	if eventBus == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := eventBus.Publish(ctx, userID, eventType, data); err != nil {
+		log.Printf("Error publishing %s for user %d: %v", eventType, userID, err)
+	}
+}
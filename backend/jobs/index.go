@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxIndexSource caps how much of a document we download to extract its text
+const maxIndexSource = 20 << 20
+
+// TypeIndex is the job type that extracts and indexes a file's text
+const TypeIndex = "index"
+
+func init() {
+	Register(TypeIndex, HandlerOptions{MaxAttempts: 3, Timeout: 5 * time.Minute}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		fileID, err := filePayload(payload)
+		if err != nil {
+			return err
+		}
+		err = IndexFile(ctx, db, fileID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Permanent(err)
+		}
+		return err
+	})
+}
+
+// EnqueueIndex schedules full-text indexing for a file
+func EnqueueIndex(db *gorm.DB, fileID uint) error {
+	_, err := EnqueueUnique(db, TypeIndex, fmt.Sprintf("%s:%d", TypeIndex, fileID), FilePayload{FileID: fileID})
+	return err
+}
+
+// IndexFile extracts a file's text and stores it with its tsvector. The file
+// name is weighted above the body so title matches rank first.
+func IndexFile(ctx context.Context, db *gorm.DB, fileID uint) error {
+	var file models.File
+	if err := db.First(&file, fileID).Error; err != nil {
+		return err
+	}
+	if !utils.Indexable(file.MimeType, file.OriginalName) {
+		return nil
+	}
+	if file.Size > maxIndexSource {
+		log.Printf("Skipping index of file %d: %d bytes exceeds limit", file.ID, file.Size)
+		return nil
+	}
+
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return err
+	}
+	obj, err := s3Client.GetObject(ctx, utils.FileObjectKey(&file))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(obj.Body, maxIndexSource))
+	obj.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	text, err := utils.ExtractText(data, file.MimeType, file.OriginalName)
+	if errors.Is(err, utils.ErrNotIndexable) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content := models.FileContent{FileID: file.ID, Content: text, IndexedAt: time.Now()}
+	if err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"content", "indexed_at", "updated_at"}),
+	}).Create(&content).Error; err != nil {
+		return err
+	}
+
+	return db.Exec(`UPDATE file_contents SET search_vector =
+		setweight(to_tsvector('english', ?), 'A') || setweight(to_tsvector('english', content), 'B')
+		WHERE file_id = ?`, file.OriginalName, file.ID).Error
+}
+
+// DeleteIndex removes a file's indexed content
+func DeleteIndex(db *gorm.DB, fileID uint) error {
+	return db.Unscoped().Where("file_id = ?", fileID).Delete(&models.FileContent{}).Error
+}
@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// TypeDigest is the scheduled job that emails each user their unread
+// notifications and prunes old ones
+const TypeDigest = "digest"
+
+// EventNotification is published on the live event stream for every new
+// in-app notification
+const EventNotification = "notification"
+
+// digestLimit caps the notifications listed in one digest email
+const digestLimit = 50
+
+// mailer sends expiry warnings and digests
+var mailer utils.Mailer = utils.LogMailer{}
+
+// SetMailer sets how email is sent
+func SetMailer(m utils.Mailer) {
+	mailer = m
+}
+
+func init() {
+	Register(TypeDigest, HandlerOptions{MaxAttempts: 1, Exclusive: true}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		if err := pruneNotifications(db, utils.EnvDuration("NOTIFICATION_RETENTION", 90*24*time.Hour)); err != nil {
+			log.Printf("Error pruning notifications: %v", err)
+		}
+		return sendDigests(ctx, db)
+	})
+}
+
+// NotificationPreferences returns a user's effective preference per type
+func NotificationPreferences(db *gorm.DB, userID uint) (map[string]models.NotificationPreference, error) {
+	prefs := make(map[string]models.NotificationPreference, len(models.NotificationTypes))
+	for _, t := range models.NotificationTypes {
+		prefs[t] = models.DefaultNotificationPreference(userID, t)
+	}
+
+	var saved []models.NotificationPreference
+	if err := db.Where("user_id = ?", userID).Find(&saved).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range saved {
+		if _, ok := prefs[p.Type]; ok {
+			prefs[p.Type] = p
+		}
+	}
+	return prefs, nil
+}
+
+func notificationPreference(db *gorm.DB, userID uint, notificationType string) models.NotificationPreference {
+	pref := models.DefaultNotificationPreference(userID, notificationType)
+	db.Where("user_id = ? AND type = ?", userID, notificationType).Limit(1).Find(&pref)
+	return pref
+}
+
+// Notify records a notification on the channels the user chose for its type
+// and pushes in-app ones to their live event stream. A notification that
+// was already emailed has EmailedAt set. Failures are logged.
+func Notify(db *gorm.DB, n models.Notification) {
+	pref := notificationPreference(db, n.UserID, n.Type)
+	if !pref.InApp && !pref.Email {
+		return
+	}
+	n.InApp, n.Email = pref.InApp, pref.Email
+	n.Title = clip(n.Title, 255)
+	n.Body = clip(n.Body, 2048)
+
+	if err := db.Create(&n).Error; err != nil {
+		log.Printf("Error saving %s notification for user %d: %v", n.Type, n.UserID, err)
+		return
+	}
+	if n.InApp {
+		publishEvent(n.UserID, EventNotification, n)
+	}
+}
+
+// clip shortens s to at most n bytes of valid UTF-8
+func clip(s string, n int) string {
+	if len(s) > n {
+		s = strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
+
+// sendDigests emails every user with unread notifications that have not
+// been emailed yet, in a single message per user
+func sendDigests(ctx context.Context, db *gorm.DB) error {
+	var userIDs []uint
+	if err := db.Model(&models.Notification{}).
+		Where("email AND emailed_at IS NULL AND read_at IS NULL").
+		Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, userID := range userIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sendDigest(db, userID); err != nil {
+			log.Printf("Error sending digest to user %d: %v", userID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d digests could not be sent", failed, len(userIDs))
+	}
+	return nil
+}
+
+func sendDigest(db *gorm.DB, userID uint) error {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	var notifications []models.Notification
+	if err := db.Where("user_id = ? AND email AND emailed_at IS NULL AND read_at IS NULL", userID).
+		Order("id").Find(&notifications).Error; err != nil {
+		return err
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\nHere is what happened since your last digest:\n\n", user.Username)
+	for i, n := range notifications {
+		if i == digestLimit {
+			fmt.Fprintf(&body, "...and %d more in the app.\n", len(notifications)-digestLimit)
+			break
+		}
+		fmt.Fprintf(&body, "- %s\n", n.Title)
+		if n.Body != "" {
+			fmt.Fprintf(&body, "  %s\n", n.Body)
+		}
+		if n.Link != "" {
+			fmt.Fprintf(&body, "  %s\n", n.Link)
+		}
+	}
+	body.WriteString("\nChange which notifications are emailed in your notification preferences.\n")
+
+	subject := fmt.Sprintf("%d new notification(s)", len(notifications))
+	if err := mailer.Send(user.Email, subject, body.String()); err != nil {
+		return err
+	}
+
+	ids := make([]uint, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+	}
+	return db.Model(&models.Notification{}).Where("id IN ?", ids).Update("emailed_at", time.Now()).Error
+}
+
+// pruneNotifications deletes notifications older than the retention period
+func pruneNotifications(db *gorm.DB, retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+	return db.Where("created_at < ?", time.Now().Add(-retention)).Delete(&models.Notification{}).Error
+}
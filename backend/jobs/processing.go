@@ -0,0 +1,282 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// TypeProcess is the job type that takes a new upload through scanning,
+// thumbnails and indexing
+const TypeProcess = "process"
+
+// EventFileProcessing is published on the live event stream whenever a
+// file's processing state changes
+const EventFileProcessing = "file.processing"
+
+func init() {
+	Register(TypeProcess, HandlerOptions{MaxAttempts: 5, Timeout: 10 * time.Minute, OnDead: processingDead}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		var p processPayload
+		if err := json.Unmarshal(payload, &p); err != nil || p.FileID == 0 {
+			return Permanent(fmt.Errorf("invalid process payload %s", payload))
+		}
+		err := ProcessFile(ctx, db, cache, p.FileID, p.Filename)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Permanent(err)
+		}
+		return err
+	})
+}
+
+// processPayload names the stored object too, so a run for content that has
+// since been replaced leaves the file alone
+type processPayload struct {
+	FileID   uint   `json:"file_id"`
+	Filename string `json:"filename"`
+}
+
+// ProcessingStatus is the processing state of a file as reported to clients
+type ProcessingStatus struct {
+	FileID      uint              `json:"file_id"`
+	Status      string            `json:"status"`
+	Steps       map[string]string `json:"steps"`
+	Error       string            `json:"error,omitempty"`
+	ProcessedAt *time.Time        `json:"processed_at,omitempty"`
+}
+
+// FileProcessingStatus reports where a file's processing stands
+func FileProcessingStatus(file *models.File) ProcessingStatus {
+	return ProcessingStatus{
+		FileID:      file.ID,
+		Status:      file.ProcessingStatus,
+		Steps:       file.ProcessingSteps,
+		Error:       file.ProcessingError,
+		ProcessedAt: file.ProcessedAt,
+	}
+}
+
+// newProcessingSteps lists the steps a file needs; the rest are skipped
+func newProcessingSteps(file *models.File) map[string]string {
+	steps := map[string]string{
+		models.StepScan:       models.StepSkipped,
+		models.StepThumbnails: models.StepSkipped,
+		models.StepIndex:      models.StepSkipped,
+	}
+	if utils.ScanEnabled() {
+		steps[models.StepScan] = models.StepPending
+	}
+	if utils.Thumbnailable(file.MimeType) && file.Size <= maxThumbnailSource {
+		steps[models.StepThumbnails] = models.StepPending
+	}
+	if utils.Indexable(file.MimeType, file.OriginalName) && file.Size <= maxIndexSource {
+		steps[models.StepIndex] = models.StepPending
+	}
+	return steps
+}
+
+// EnqueueProcessing resets a new or replaced file to uploaded and schedules
+// its processing
+func EnqueueProcessing(db *gorm.DB, file *models.File) error {
+	file.ProcessingStatus = models.ProcessingUploaded
+	file.ProcessingSteps = newProcessingSteps(file)
+	file.ProcessingError = ""
+	file.ProcessedAt = nil
+	if err := saveProcessing(db, nil, file); err != nil {
+		return err
+	}
+	_, err := EnqueueUnique(db, TypeProcess, fmt.Sprintf("%s:%d:%s", TypeProcess, file.ID, file.Filename),
+		processPayload{FileID: file.ID, Filename: file.Filename})
+	return err
+}
+
+// saveProcessing stores a file's processing state, publishes it and drops
+// the owner's cached listings, unless cache is nil because the caller does
+// that itself. Nothing is written once the content has been replaced.
+func saveProcessing(db *gorm.DB, cache utils.Cache, file *models.File) error {
+	result := db.Model(file).Where("filename = ?", file.Filename).
+		Select("ProcessingStatus", "ProcessingSteps", "ProcessingError", "ProcessedAt").Updates(file)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		publishEvent(file.UserID, EventFileProcessing, FileProcessingStatus(file))
+		if cache != nil {
+			utils.InvalidateFileListings(context.Background(), cache, file.UserID)
+		}
+	}
+	return nil
+}
+
+// stepDone reports whether a step needs no more work
+func stepDone(state string) bool {
+	return state == models.StepDone || state == models.StepSkipped || state == models.StepFailed
+}
+
+// ProcessFile runs the outstanding steps of a file. Finished steps are kept
+// between attempts, so a retry resumes where the last attempt failed.
+func ProcessFile(ctx context.Context, db *gorm.DB, cache utils.Cache, fileID uint, filename string) error {
+	var file models.File
+	if err := db.First(&file, fileID).Error; err != nil {
+		return err
+	}
+	if file.Filename != filename {
+		return nil
+	}
+	if file.ProcessingSteps == nil {
+		file.ProcessingSteps = newProcessingSteps(&file)
+	}
+	steps := file.ProcessingSteps
+
+	if steps[models.StepScan] == models.StepInfected {
+		return nil
+	}
+	if !stepDone(steps[models.StepScan]) {
+		file.ProcessingStatus = models.ProcessingScanning
+		if err := saveProcessing(db, cache, &file); err != nil {
+			return err
+		}
+
+		signature, err := scanFile(ctx, &file)
+		switch {
+		case errors.Is(err, utils.ErrScanTooLarge):
+			steps[models.StepScan] = models.StepSkipped
+		case err != nil:
+			return fmt.Errorf("scan: %w", err)
+		case signature != "":
+			steps[models.StepScan] = models.StepInfected
+			return finishProcessing(db, cache, &file, models.ProcessingFailed, "Malware detected: "+signature)
+		default:
+			steps[models.StepScan] = models.StepDone
+		}
+	}
+
+	file.ProcessingStatus = models.ProcessingProcessing
+	if err := saveProcessing(db, cache, &file); err != nil {
+		return err
+	}
+
+	fileSteps := []struct {
+		name string
+		run  func(context.Context, *gorm.DB, uint) error
+	}{
+		{models.StepThumbnails, GenerateThumbnails},
+		{models.StepIndex, IndexFile},
+	}
+	failures := map[string]string{}
+	for _, step := range fileSteps {
+		if stepDone(steps[step.name]) {
+			continue
+		}
+		err := step.run(ctx, db, file.ID)
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			// Retrying cannot help; record it and carry on with the other steps
+			steps[step.name] = models.StepFailed
+			failures[step.name] = err.Error()
+			if err := saveProcessing(db, cache, &file); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			// Keep the error visible while the job retries
+			file.ProcessingError = processingMessage(step.name + ": " + err.Error())
+			saveProcessing(db, cache, &file)
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+		steps[step.name] = models.StepDone
+		if err := saveProcessing(db, cache, &file); err != nil {
+			return err
+		}
+	}
+
+	// Steps may also have failed in an earlier attempt
+	var failed []string
+	for _, step := range fileSteps {
+		if steps[step.name] != models.StepFailed {
+			continue
+		}
+		message, ok := failures[step.name]
+		if !ok {
+			message = "failed"
+		}
+		failed = append(failed, step.name+": "+message)
+	}
+	if len(failed) > 0 {
+		return finishProcessing(db, cache, &file, models.ProcessingFailed, strings.Join(failed, "; "))
+	}
+	return finishProcessing(db, cache, &file, models.ProcessingReady, "")
+}
+
+func finishProcessing(db *gorm.DB, cache utils.Cache, file *models.File, status, message string) error {
+	now := time.Now()
+	file.ProcessingStatus = status
+	file.ProcessingError = processingMessage(message)
+	file.ProcessedAt = &now
+	if err := saveProcessing(db, cache, file); err != nil {
+		return err
+	}
+
+	if status == models.ProcessingFailed {
+		Notify(db, models.Notification{
+			UserID: file.UserID,
+			Type:   models.NotifyProcessingFailed,
+			Title:  "Processing " + file.OriginalName + " failed",
+			Body:   file.ProcessingError,
+			Data:   map[string]interface{}{"file_id": file.ID},
+		})
+	}
+	return nil
+}
+
+// processingMessage fits a message into File.ProcessingError
+func processingMessage(message string) string {
+	if len(message) > 512 {
+		message = strings.ToValidUTF8(message[:512], "")
+	}
+	return message
+}
+
+// scanFile streams a file's object to the malware scanner
+func scanFile(ctx context.Context, file *models.File) (string, error) {
+	if file.Size > utils.ScanMaxSize() {
+		return "", utils.ErrScanTooLarge
+	}
+
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return "", err
+	}
+	obj, err := s3Client.GetObject(ctx, utils.FileObjectKey(file))
+	if err != nil {
+		return "", err
+	}
+	defer obj.Body.Close()
+	return utils.ScanStream(ctx, obj.Body)
+}
+
+// processingDead marks the unfinished steps failed once retries run out
+func processingDead(db *gorm.DB, cache utils.Cache, payload json.RawMessage, jobErr error) {
+	var p processPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return
+	}
+	var file models.File
+	if err := db.First(&file, p.FileID).Error; err != nil || file.Filename != p.Filename {
+		return
+	}
+	for name, state := range file.ProcessingSteps {
+		if state == models.StepPending {
+			file.ProcessingSteps[name] = models.StepFailed
+		}
+	}
+	finishProcessing(db, cache, &file, models.ProcessingFailed, jobErr.Error())
+}
@@ -0,0 +1,537 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Handler runs one job. Returning an error schedules a retry with
+// exponential backoff unless the error is wrapped with Permanent.
+type Handler func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error
+
+// HandlerOptions tune how a job type is run
+type HandlerOptions struct {
+	MaxAttempts int
+	Timeout     time.Duration
+	// Exclusive jobs hold a lease while they run, so at most one
+	// instance runs the job type at a time
+	Exclusive bool
+	// OnDead is called once the job has failed for good
+	OnDead func(db *gorm.DB, cache utils.Cache, payload json.RawMessage, err error)
+}
+
+type registeredHandler struct {
+	fn   Handler
+	opts HandlerOptions
+}
+
+var handlers = map[string]registeredHandler{}
+
+// Register adds the handler for a job type. It is meant to be called from
+// init functions, before the queue starts.
+func Register(jobType string, opts HandlerOptions, fn Handler) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Minute
+	}
+	handlers[jobType] = registeredHandler{fn: fn, opts: opts}
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job goes straight to the dead letters
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// FilePayload is the payload of jobs that work on a single file
+type FilePayload struct {
+	FileID uint `json:"file_id"`
+}
+
+// filePayload decodes a FilePayload, treating malformed payloads as permanent failures
+func filePayload(payload json.RawMessage) (uint, error) {
+	var p FilePayload
+	if err := json.Unmarshal(payload, &p); err != nil || p.FileID == 0 {
+		return 0, Permanent(fmt.Errorf("invalid file payload %s", payload))
+	}
+	return p.FileID, nil
+}
+
+// wake nudges idle workers when a job is enqueued by this process
+var wake = make(chan struct{}, 1)
+
+// Enqueue adds a job that runs as soon as a worker is free
+func Enqueue(db *gorm.DB, jobType string, payload interface{}) (*models.Job, error) {
+	return EnqueueAt(db, jobType, "", payload, time.Now())
+}
+
+// activeKeyIndex is the predicate of the partial unique index that allows
+// one pending or running job per key
+const activeKeyIndex = "key <> '' AND status IN ('pending', 'running') AND deleted_at IS NULL"
+
+// MigrateQueue creates the unique index on the keys of active jobs. Active
+// duplicates left by earlier races are marked dead first.
+func MigrateQueue(db *gorm.DB) error {
+	if err := db.Exec(`UPDATE jobs SET status = ?, last_error = ?, finished_at = ?, locked_by = '', locked_at = NULL
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY key ORDER BY status = 'running' DESC, id) AS n
+				FROM jobs WHERE `+activeKeyIndex+`
+			) ranked WHERE n > 1
+		)`, models.JobDead, "duplicate of an active job with the same key", time.Now()).Error; err != nil {
+		return err
+	}
+	return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_active_key ON jobs (key) WHERE ` + activeKeyIndex).Error
+}
+
+// EnqueueUnique adds a job unless one with the same key is still pending or
+// running, so repeated requests for the same work collapse into one job.
+// The unique index on active keys settles races between callers.
+func EnqueueUnique(db *gorm.DB, jobType, key string, payload interface{}) (*models.Job, error) {
+	job, err := newJob(jobType, key, payload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := db.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "key"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: activeKeyIndex}}},
+		DoNothing:   true,
+	}).Create(job)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		var existing models.Job
+		err := db.Where("key = ? AND status IN ?", key, []string{models.JobPending, models.JobRunning}).First(&existing).Error
+		return &existing, err
+	}
+
+	wakeWorkers()
+	return job, nil
+}
+
+// EnqueueAt adds a job that becomes runnable at runAt
+func EnqueueAt(db *gorm.DB, jobType, key string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	job, err := newJob(jobType, key, payload, runAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	wakeWorkers()
+	return job, nil
+}
+
+func newJob(jobType, key string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	handler, ok := handlers[jobType]
+	if !ok {
+		return nil, fmt.Errorf("no handler registered for job type %q", jobType)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Job{
+		Type:        jobType,
+		Key:         key,
+		Payload:     data,
+		Status:      models.JobPending,
+		RunAt:       runAt,
+		MaxAttempts: handler.opts.MaxAttempts,
+	}, nil
+}
+
+// wakeWorkers nudges an idle worker in this process
+func wakeWorkers() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartQueue starts the worker pool, the scheduler for periodic jobs and the
+// reaper that recovers jobs from crashed workers. Handlers get the cache;
+// leases elect the scheduler and guard exclusive jobs.
+func StartQueue(db *gorm.DB, cache utils.Cache, leases utils.LeaseStore) {
+	workers := int(utils.EnvInt64("JOB_WORKERS", 4))
+	poll := utils.EnvDuration("JOB_POLL_INTERVAL", 2*time.Second)
+	hostname, _ := os.Hostname()
+
+	for i := 0; i < workers; i++ {
+		workerID := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), i)
+		go func() {
+			for {
+				job, err := claimJob(db, workerID)
+				if err != nil {
+					log.Printf("Error claiming job: %v", err)
+				}
+				if job != nil {
+					runJob(db, cache, leases, job)
+					continue
+				}
+
+				// Nothing due: wait for a local enqueue or the next poll
+				select {
+				case <-wake:
+				case <-time.After(poll):
+				}
+			}
+		}()
+	}
+
+	go runScheduler(db, leases, registerSchedules())
+	go runReaper(db, cache)
+}
+
+// claimJob locks the next due job with SKIP LOCKED so concurrent workers,
+// in this process or others, never pick up the same job.
+func claimJob(db *gorm.DB, workerID string) (*models.Job, error) {
+	now := time.Now()
+	var job models.Job
+	result := db.Raw(`UPDATE jobs SET status = ?, attempts = attempts + 1, locked_by = ?, locked_at = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = ? AND run_at <= ? AND deleted_at IS NULL
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, models.JobRunning, workerID, now, now, models.JobPending, now).Scan(&job)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func runJob(db *gorm.DB, cache utils.Cache, leases utils.LeaseStore, job *models.Job) {
+	handler, ok := handlers[job.Type]
+	if !ok {
+		finishJob(db, cache, job, Permanent(fmt.Errorf("no handler registered for job type %q", job.Type)))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handler.opts.Timeout)
+	defer cancel()
+
+	if handler.opts.Exclusive {
+		lease, err := leases.Acquire(ctx, "job:"+job.Type, leaseTTL())
+		if errors.Is(err, utils.ErrLeaseHeld) {
+			log.Printf("Deferring job %d (%s): already running on another instance", job.ID, job.Type)
+			deferJob(db, job, leaseTTL())
+			return
+		}
+		if errors.Is(err, utils.ErrLeaseUnavailable) {
+			log.Printf("Deferring job %d (%s): %v", job.ID, job.Type, err)
+			deferJob(db, job, leaseTTL())
+			return
+		}
+		if err != nil {
+			finishJob(db, cache, job, err)
+			return
+		}
+		// The handler's context is cancelled if the lease is lost mid-run
+		var release context.CancelFunc
+		ctx, release = lease.Hold(ctx)
+		defer release()
+	}
+
+	// Handlers may outlive their timeout if they ignore ctx; the heartbeat
+	// keeps the reaper away for as long as they actually run
+	stop := make(chan struct{})
+	go heartbeatJob(db, job, stop)
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return handler.fn(ctx, db, cache, job.Payload)
+	}()
+	close(stop)
+	finishJob(db, cache, job, err)
+}
+
+// lockTimeout is how long a running job may go without a heartbeat before
+// its worker is presumed dead
+func lockTimeout() time.Duration {
+	return utils.EnvDuration("JOB_LOCK_TIMEOUT", 5*time.Minute)
+}
+
+// heartbeatJob refreshes the job's lock until stop is closed
+func heartbeatJob(db *gorm.DB, job *models.Job, stop <-chan struct{}) {
+	ticker := time.NewTicker(lockTimeout() / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := db.Model(&models.Job{}).Where("id = ? AND locked_by = ? AND status = ?", job.ID, job.LockedBy, models.JobRunning).
+				Update("locked_at", time.Now()).Error; err != nil {
+				log.Printf("Error refreshing lock of job %d: %v", job.ID, err)
+			}
+		}
+	}
+}
+
+// finishJob records the outcome of an attempt: success, a retry after a
+// backoff, or a dead letter once the attempts are used up. Nothing is
+// written if the worker no longer holds the job, so a late finish cannot
+// overwrite what the reaper decided.
+func finishJob(db *gorm.DB, cache utils.Cache, job *models.Job, jobErr error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"locked_by": "",
+		"locked_at": nil,
+	}
+
+	var permanent permanentError
+	dead := false
+	switch {
+	case jobErr == nil:
+		updates["status"] = models.JobSucceeded
+		updates["finished_at"] = now
+	case errors.As(jobErr, &permanent) || job.Attempts >= job.MaxAttempts:
+		log.Printf("Job %d (%s) failed permanently after %d attempts: %v", job.ID, job.Type, job.Attempts, jobErr)
+		updates["status"] = models.JobDead
+		updates["last_error"] = jobErr.Error()
+		updates["finished_at"] = now
+		dead = true
+	default:
+		log.Printf("Job %d (%s) failed, retrying: %v", job.ID, job.Type, jobErr)
+		updates["status"] = models.JobPending
+		updates["last_error"] = jobErr.Error()
+		updates["run_at"] = now.Add(retryBackoff(job.Attempts))
+	}
+
+	result := db.Model(&models.Job{}).Where("id = ? AND status = ? AND locked_by = ?", job.ID, models.JobRunning, job.LockedBy).Updates(updates)
+	if result.Error != nil {
+		log.Printf("Error updating job %d: %v", job.ID, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		log.Printf("Job %d (%s) finished after losing its lock; result discarded", job.ID, job.Type)
+		return
+	}
+	if onDead := handlers[job.Type].opts.OnDead; dead && onDead != nil {
+		onDead(db, cache, job.Payload, jobErr)
+	}
+}
+
+// deferJob puts a claimed job back in the queue to run after delay, without
+// using up an attempt
+func deferJob(db *gorm.DB, job *models.Job, delay time.Duration) {
+	err := db.Model(&models.Job{}).Where("id = ? AND status = ? AND locked_by = ?", job.ID, models.JobRunning, job.LockedBy).
+		Updates(map[string]interface{}{
+			"status":    models.JobPending,
+			"attempts":  gorm.Expr("attempts - 1"),
+			"run_at":    time.Now().Add(delay),
+			"locked_by": "",
+			"locked_at": nil,
+		}).Error
+	if err != nil {
+		log.Printf("Error deferring job %d: %v", job.ID, err)
+	}
+}
+
+// retryBackoff doubles the delay after every attempt, with jitter so failed
+// jobs do not all retry at once
+func retryBackoff(attempts int) time.Duration {
+	base := utils.EnvDuration("JOB_RETRY_BASE", 10*time.Second)
+	max := utils.EnvDuration("JOB_RETRY_MAX", time.Hour)
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - delay/10 + jitter
+}
+
+// RetryJob puts a dead or finished job back in the queue with fresh attempts
+func RetryJob(db *gorm.DB, jobID uint) (*models.Job, error) {
+	var job models.Job
+	if err := db.First(&job, jobID).Error; err != nil {
+		return nil, err
+	}
+	if job.Status == models.JobPending || job.Status == models.JobRunning {
+		return &job, nil
+	}
+
+	err := db.Model(&job).Updates(map[string]interface{}{
+		"status":      models.JobPending,
+		"attempts":    0,
+		"run_at":      time.Now(),
+		"finished_at": nil,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	wakeWorkers()
+	return &job, db.First(&job, jobID).Error
+}
+
+// schedule is a periodic job registered at startup
+type schedule struct {
+	name     string
+	jobType  string
+	interval time.Duration
+}
+
+// registerSchedules lists the periodic jobs. Intervals are read here rather
+// than in init so values from .env are seen.
+func registerSchedules() []schedule {
+	return []schedule{
+		{name: "cleanup", jobType: TypeCleanup, interval: utils.EnvDuration("CLEANUP_INTERVAL", time.Hour)},
+		{name: "scrub", jobType: TypeScrub, interval: utils.EnvDuration("SCRUB_INTERVAL", 24*time.Hour)},
+		{name: "reconcile", jobType: TypeReconcile, interval: utils.EnvDuration("RECONCILE_INTERVAL", 6*time.Hour)},
+		{name: "trash", jobType: TypeTrash, interval: utils.EnvDuration("TRASH_INTERVAL", time.Hour)},
+		{name: "digest", jobType: TypeDigest, interval: utils.EnvDuration("DIGEST_INTERVAL", 24*time.Hour)},
+	}
+}
+
+// leaseTTL is how long a lease survives without renewal, and so how quickly
+// another instance takes over from one that died
+func leaseTTL() time.Duration {
+	return utils.EnvDuration("LEASE_TTL", 30*time.Second)
+}
+
+// runScheduler registers the schedules, then competes for the scheduler
+// lease. Only the leader enqueues periodic jobs; when it dies its lease
+// expires and another instance takes over.
+func runScheduler(db *gorm.DB, leases utils.LeaseStore, schedules []schedule) {
+	for _, s := range schedules {
+		row := models.JobSchedule{Name: s.name, JobType: s.jobType, IntervalSeconds: int64(s.interval / time.Second), NextRunAt: time.Now()}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"job_type", "interval_seconds"}),
+		}).Create(&row).Error
+		if err != nil {
+			log.Printf("Error registering schedule %s: %v", s.name, err)
+		}
+	}
+
+	ttl := leaseTTL()
+	for {
+		lease, err := leases.Acquire(context.Background(), "scheduler", ttl)
+		if err != nil {
+			if !errors.Is(err, utils.ErrLeaseHeld) {
+				log.Printf("Error acquiring scheduler lease: %v", err)
+			}
+			time.Sleep(ttl / 3)
+			continue
+		}
+
+		// A token no newer than one already recorded means the fencing
+		// counter was lost, say to a Redis flush, and no claim would match.
+		// Raise the counter past the recorded tokens and acquire again.
+		var recorded int64
+		if err := db.Model(&models.JobSchedule{}).Select("COALESCE(MAX(fence), 0)").Scan(&recorded).Error; err != nil {
+			log.Printf("Error reading schedule fences: %v", err)
+			lease.Release(context.Background())
+			time.Sleep(ttl / 3)
+			continue
+		}
+		if lease.Token <= recorded {
+			log.Printf("Warning: scheduler fencing token %d is behind the recorded token %d, reseeding the counter", lease.Token, recorded)
+			err := leases.SeedFence(context.Background(), "scheduler", recorded)
+			lease.Release(context.Background())
+			if err != nil {
+				log.Printf("Error reseeding the scheduler fencing counter: %v", err)
+				time.Sleep(ttl / 3)
+			}
+			continue
+		}
+
+		log.Printf("Acting as job scheduler (fencing token %d)", lease.Token)
+		ctx, release := lease.Hold(context.Background())
+		runSchedules(ctx, db, schedules, lease.Token)
+		release()
+	}
+}
+
+// runSchedules enqueues each periodic job when it is due until ctx ends.
+// Claiming a run requires a fencing token at least as new as the last one
+// recorded, so a leader that lost its lease cannot enqueue a duplicate.
+func runSchedules(ctx context.Context, db *gorm.DB, schedules []schedule, token int64) {
+	for ctx.Err() == nil {
+		now := time.Now()
+		for _, s := range schedules {
+			var row models.JobSchedule
+			if err := db.First(&row, "name = ?", s.name).Error; err != nil || row.NextRunAt.After(now) {
+				continue
+			}
+
+			claimed := db.Model(&models.JobSchedule{}).
+				Where("name = ? AND next_run_at = ? AND fence <= ?", s.name, row.NextRunAt, token).
+				Updates(map[string]interface{}{"next_run_at": now.Add(s.interval), "fence": token})
+			if claimed.Error != nil || claimed.RowsAffected == 0 {
+				continue
+			}
+
+			// A slow run of the previous period is still going; skip this one
+			if _, err := EnqueueUnique(db, s.jobType, "schedule:"+s.name, struct{}{}); err != nil {
+				log.Printf("Error enqueuing scheduled job %s: %v", s.name, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(15 * time.Second):
+		}
+	}
+}
+
+// runReaper returns jobs whose worker stopped sending heartbeats to the
+// queue and prunes old successful jobs. Dead jobs are kept until retried or
+// removed by hand.
+func runReaper(db *gorm.DB, cache utils.Cache) {
+	timeout := lockTimeout()
+	retention := utils.EnvDuration("JOB_RETENTION", 7*24*time.Hour)
+
+	for {
+		var stale []models.Job
+		if err := db.Where("status = ? AND locked_at < ?", models.JobRunning, time.Now().Add(-timeout)).Find(&stale).Error; err != nil {
+			log.Printf("Error finding stale jobs: %v", err)
+		}
+		for i := range stale {
+			finishJob(db, cache, &stale[i], errors.New("worker lock expired"))
+		}
+
+		if err := db.Unscoped().Where("status = ? AND finished_at < ?", models.JobSucceeded, time.Now().Add(-retention)).
+			Delete(&models.Job{}).Error; err != nil {
+			log.Printf("Error pruning finished jobs: %v", err)
+		}
+
+		time.Sleep(time.Minute)
+	}
+}
@@ -0,0 +1,229 @@
+package jobs
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testJobType = "test.queue"
+
+// newMockDB returns a gorm DB backed by sqlmock. Every expectation must be
+// met by the end of the test.
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqlDB.Close()
+	})
+	return db, mock
+}
+
+// registerTestHandler registers testJobType and returns the errors passed
+// to its OnDead hook
+func registerTestHandler(t *testing.T, opts HandlerOptions) *[]error {
+	var dead []error
+	opts.OnDead = func(db *gorm.DB, cache utils.Cache, payload json.RawMessage, err error) {
+		dead = append(dead, err)
+	}
+	Register(testJobType, opts, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		return nil
+	})
+	t.Cleanup(func() { delete(handlers, testJobType) })
+	return &dead
+}
+
+func runningJob(attempts, maxAttempts int) *models.Job {
+	job := &models.Job{Type: testJobType, Status: models.JobRunning, Attempts: attempts, MaxAttempts: maxAttempts, LockedBy: "worker-1"}
+	job.ID = 7
+	return job
+}
+
+// expectJobUpdate expects the conditional update finishJob and deferJob
+// make, with set listing the columns in the order gorm writes them
+func expectJobUpdate(mock sqlmock.Sqlmock, set string, args ...driver.Value) *sqlmock.ExpectedExec {
+	n := len(args)
+	query := fmt.Sprintf(`UPDATE "jobs" SET %s WHERE (id = $%d AND status = $%d AND locked_by = $%d) AND "jobs"."deleted_at" IS NULL`,
+		set, n+1, n+2, n+3)
+	args = append(args, 7, models.JobRunning, "worker-1")
+	return mock.ExpectExec(regexp.QuoteMeta(query)).WithArgs(args...)
+}
+
+func TestFinishJob(t *testing.T) {
+	anyArg := sqlmock.AnyArg()
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		set      string
+		args     []driver.Value
+		dead     bool
+	}{
+		{
+			name:     "success",
+			attempts: 1,
+			set:      `"finished_at"=$1,"locked_at"=$2,"locked_by"=$3,"status"=$4,"updated_at"=$5`,
+			args:     []driver.Value{anyArg, nil, "", models.JobSucceeded, anyArg},
+		},
+		{
+			name:     "retry",
+			attempts: 1,
+			err:      errors.New("timeout"),
+			set:      `"last_error"=$1,"locked_at"=$2,"locked_by"=$3,"run_at"=$4,"status"=$5,"updated_at"=$6`,
+			args:     []driver.Value{"timeout", nil, "", anyArg, models.JobPending, anyArg},
+		},
+		{
+			name:     "attempts used up",
+			attempts: 3,
+			err:      errors.New("timeout"),
+			set:      `"finished_at"=$1,"last_error"=$2,"locked_at"=$3,"locked_by"=$4,"status"=$5,"updated_at"=$6`,
+			args:     []driver.Value{anyArg, "timeout", nil, "", models.JobDead, anyArg},
+			dead:     true,
+		},
+		{
+			name:     "permanent",
+			attempts: 1,
+			err:      Permanent(errors.New("corrupt")),
+			set:      `"finished_at"=$1,"last_error"=$2,"locked_at"=$3,"locked_by"=$4,"status"=$5,"updated_at"=$6`,
+			args:     []driver.Value{anyArg, "corrupt", nil, "", models.JobDead, anyArg},
+			dead:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dead := registerTestHandler(t, HandlerOptions{MaxAttempts: 3})
+			db, mock := newMockDB(t)
+			expectJobUpdate(mock, tt.set, tt.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			finishJob(db, nil, runningJob(tt.attempts, 3), tt.err)
+
+			if got := len(*dead) == 1; got != tt.dead {
+				t.Errorf("OnDead called = %v, want %v", got, tt.dead)
+			}
+		})
+	}
+}
+
+func TestFinishJobAfterLosingLock(t *testing.T) {
+	dead := registerTestHandler(t, HandlerOptions{MaxAttempts: 1})
+	db, mock := newMockDB(t)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET`)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	finishJob(db, nil, runningJob(1, 1), Permanent(errors.New("corrupt")))
+
+	if len(*dead) != 0 {
+		t.Errorf("OnDead called for a job the worker no longer held")
+	}
+}
+
+func TestDeferJob(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectJobUpdate(mock, `"attempts"=attempts - 1,"locked_at"=$1,"locked_by"=$2,"run_at"=$3,"status"=$4,"updated_at"=$5`,
+		nil, "", sqlmock.AnyArg(), models.JobPending, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deferJob(db, runningJob(1, 3), time.Minute)
+}
+
+func TestRunJobDefersExclusiveJob(t *testing.T) {
+	held := utils.NewLocalLeases()
+	if _, err := held.Acquire(context.Background(), "job:"+testJobType, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	mr.Close()
+
+	tests := []struct {
+		name   string
+		leases utils.LeaseStore
+	}{
+		{"lease held elsewhere", held},
+		{"redis unreachable", utils.NewRedisLeases(client)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dead := registerTestHandler(t, HandlerOptions{MaxAttempts: 1, Exclusive: true})
+			db, mock := newMockDB(t)
+			mock.ExpectExec(regexp.QuoteMeta(`UPDATE "jobs" SET "attempts"=attempts - 1`)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			runJob(db, nil, tt.leases, runningJob(1, 1))
+
+			if len(*dead) != 0 {
+				t.Errorf("deferred job was marked dead")
+			}
+		})
+	}
+}
+
+func TestRunJobUnknownType(t *testing.T) {
+	db, mock := newMockDB(t)
+	job := runningJob(1, 5)
+	job.Type = "test.unregistered"
+	expectJobUpdate(mock, `"finished_at"=$1,"last_error"=$2,"locked_at"=$3,"locked_by"=$4,"status"=$5,"updated_at"=$6`,
+		sqlmock.AnyArg(), sqlmock.AnyArg(), nil, "", models.JobDead, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	runJob(db, nil, utils.NewLocalLeases(), job)
+}
+
+func TestClaimJob(t *testing.T) {
+	claim := regexp.QuoteMeta(`UPDATE jobs SET status = $1, attempts = attempts + 1`)
+
+	t.Run("empty queue", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(claim).
+			WithArgs(models.JobRunning, "worker-1", sqlmock.AnyArg(), sqlmock.AnyArg(), models.JobPending, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		job, err := claimJob(db, "worker-1")
+		if err != nil || job != nil {
+			t.Errorf("claimJob = %+v, %v, want nil, nil", job, err)
+		}
+	})
+
+	t.Run("due job", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(claim).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "type", "status", "attempts", "max_attempts", "locked_by"}).
+				AddRow(7, testJobType, models.JobRunning, 2, 5, "worker-1"))
+
+		job, err := claimJob(db, "worker-1")
+		if err != nil || job == nil {
+			t.Fatalf("claimJob = %+v, %v, want a job", job, err)
+		}
+		if job.ID != 7 || job.Attempts != 2 || job.LockedBy != "worker-1" {
+			t.Errorf("claimJob = %+v, want job 7 on its second attempt locked by worker-1", job)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// Repair policies for the reconcile job, selected with RECONCILE_POLICY
+const (
+	ReconcileReportOnly    = "report"
+	ReconcileDeleteOrphans = "delete-orphans"
+	ReconcileDeleteGhosts  = "delete-ghosts"
+	ReconcileRepairAll     = "repair-all"
+)
+
+// ReconcileConfig controls what a reconcile pass is allowed to change
+type ReconcileConfig struct {
+	Policy string
+	DryRun bool
+	// Grace skips objects and rows younger than this so in-flight uploads are not touched
+	Grace time.Duration
+}
+
+func (c ReconcileConfig) repairOrphans() bool {
+	return !c.DryRun && (c.Policy == ReconcileDeleteOrphans || c.Policy == ReconcileRepairAll)
+}
+
+func (c ReconcileConfig) repairGhosts() bool {
+	return !c.DryRun && (c.Policy == ReconcileDeleteGhosts || c.Policy == ReconcileRepairAll)
+}
+
+// TypeReconcile is the scheduled job that diffs the bucket against the files table
+const TypeReconcile = "reconcile"
+
+func init() {
+	Register(TypeReconcile, HandlerOptions{MaxAttempts: 1, Timeout: 6 * time.Hour, Exclusive: true}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		cfg := ReconcileConfig{
+			Policy: os.Getenv("RECONCILE_POLICY"),
+			DryRun: utils.EnvBool("RECONCILE_DRY_RUN", false),
+			Grace:  utils.EnvDuration("RECONCILE_GRACE", time.Hour),
+		}
+		if cfg.Policy == "" {
+			cfg.Policy = ReconcileReportOnly
+		}
+
+		run, err := RunReconcile(ctx, db, cfg)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reconcile finished: %d orphans, %d ghosts, %d repaired", run.Orphans, run.Ghosts, run.Repaired)
+		return nil
+	})
+}
+
+// storedFile is the subset of a file row needed to match it to an object
+type storedFile struct {
+	ID        uint
+	ObjectKey string
+	Size      int64
+	CreatedAt time.Time
+	Deleted   bool
+	seen      bool
+}
+
+// RunReconcile performs a single reconcile pass and records its findings
+func RunReconcile(ctx context.Context, db *gorm.DB, cfg ReconcileConfig) (*models.ReconcileRun, error) {
+	switch cfg.Policy {
+	case ReconcileReportOnly, ReconcileDeleteOrphans, ReconcileDeleteGhosts, ReconcileRepairAll:
+	default:
+		return nil, fmt.Errorf("unknown reconcile policy %q", cfg.Policy)
+	}
+
+	run := models.ReconcileRun{
+		Policy:    cfg.Policy,
+		DryRun:    cfg.DryRun,
+		StartedAt: time.Now(),
+	}
+	if err := db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+
+	err := reconcile(ctx, db, cfg, &run)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	finished := time.Now()
+	run.FinishedAt = &finished
+	if err := db.Save(&run).Error; err != nil {
+		log.Printf("Error saving reconcile run %d: %v", run.ID, err)
+	}
+
+	return &run, err
+}
+
+func reconcile(ctx context.Context, db *gorm.DB, cfg ReconcileConfig, run *models.ReconcileRun) error {
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-cfg.Grace)
+
+	// Soft-deleted rows still own their objects, so they are never orphans
+	known := make(map[string]*storedFile)
+	var files []models.File
+	err = db.Unscoped().Select("id", "filename", "object_key", "size", "created_at", "deleted_at").
+		FindInBatches(&files, 500, func(tx *gorm.DB, batch int) error {
+			for _, f := range files {
+				known[utils.FileObjectKey(&f)] = &storedFile{
+					ID:        f.ID,
+					ObjectKey: f.ObjectKey,
+					Size:      f.Size,
+					CreatedAt: f.CreatedAt,
+					Deleted:   f.DeletedAt.Valid,
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	// Orphans: objects with no matching row
+	err = s3Client.ListObjects(ctx, "uploads/", func(obj utils.ObjectInfo) error {
+		if f, ok := known[obj.Key]; ok {
+			f.seen = true
+			return nil
+		}
+		if obj.LastModified.After(cutoff) {
+			return nil
+		}
+
+		item := models.ReconcileItem{
+			RunID: run.ID,
+			Kind:  models.ReconcileOrphan,
+			Key:   obj.Key,
+			Size:  obj.Size,
+		}
+		if cfg.repairOrphans() {
+			if err := s3Client.DeleteObject(ctx, obj.Key); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Repaired = true
+			}
+		}
+		run.Orphans++
+		return saveReconcileItem(db, run, &item)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Ghosts: live rows whose object was not listed
+	for key, f := range known {
+		if f.seen || f.Deleted || f.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		// The row may have been replaced or uploaded again since the snapshot
+		// was taken, so check the object itself before calling it a ghost
+		if err := s3Client.HeadObject(ctx, key); !utils.IsNoSuchKey(err) {
+			if err != nil {
+				log.Printf("Reconcile: could not check object %s of file %d: %v", key, f.ID, err)
+			}
+			continue
+		}
+
+		fileID := f.ID
+		item := models.ReconcileItem{
+			RunID:  run.ID,
+			Kind:   models.ReconcileGhost,
+			Key:    key,
+			FileID: &fileID,
+			Size:   f.Size,
+		}
+		if cfg.repairGhosts() {
+			// Only delete the row if it still points at the missing object
+			result := db.Where("object_key = ?", f.ObjectKey).Delete(&models.File{}, f.ID)
+			switch {
+			case result.Error != nil:
+				item.Error = result.Error.Error()
+			case result.RowsAffected == 0:
+				item.Error = "file changed during reconcile"
+			default:
+				item.Repaired = true
+			}
+		}
+		run.Ghosts++
+		if err := saveReconcileItem(db, run, &item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func saveReconcileItem(db *gorm.DB, run *models.ReconcileRun, item *models.ReconcileItem) error {
+	if item.Repaired {
+		run.Repaired++
+	}
+	return db.Create(item).Error
+}
@@ -0,0 +1,120 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TypeScrub is the scheduled job that re-reads stored objects and records
+// files whose content no longer matches the checksums captured at upload.
+const TypeScrub = "scrub"
+
+func init() {
+	Register(TypeScrub, HandlerOptions{MaxAttempts: 1, Timeout: 24 * time.Hour, Exclusive: true}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		return scrubFiles(ctx, db, utils.EnvInt64("SCRUB_RATE_BYTES", 5<<20)) // bytes per second
+	})
+}
+
+func scrubFiles(ctx context.Context, db *gorm.DB, rate int64) error {
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return err
+	}
+
+	var files []models.File
+	return db.FindInBatches(&files, 100, func(tx *gorm.DB, batch int) error {
+		for i := range files {
+			// Stop if the job's lease was lost
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			size := scrubFile(ctx, db, s3Client, &files[i])
+
+			// Throttle reads so scrubbing does not compete with user traffic
+			if rate > 0 && size > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Duration(size * int64(time.Second) / rate)):
+				}
+			}
+		}
+		return nil
+	}).Error
+}
+
+// scrubFile verifies a single file and returns the number of bytes read
+func scrubFile(ctx context.Context, db *gorm.DB, s3Client *utils.S3Client, file *models.File) int64 {
+	report := models.IntegrityReport{
+		FileID:         file.ID,
+		ExpectedSHA256: file.ChecksumSHA256,
+		ExpectedSize:   file.Size,
+		CheckedAt:      time.Now(),
+	}
+
+	obj, err := s3Client.GetObject(ctx, utils.FileObjectKey(file))
+	if utils.IsNoSuchKey(err) {
+		report.Status = models.IntegrityMissing
+		report.Detail = err.Error()
+		saveIntegrityReport(db, &report)
+		return 0
+	}
+	if err != nil {
+		// S3 being unreachable says nothing about the file; check it next pass
+		log.Printf("Error fetching file %d during scrub: %v", file.ID, err)
+		return 0
+	}
+	defer obj.Body.Close()
+
+	sums, n, err := utils.ComputeChecksums(obj.Body)
+	if err != nil {
+		log.Printf("Error reading file %d during scrub: %v", file.ID, err)
+		return n
+	}
+
+	// Files uploaded before checksums existed get theirs backfilled
+	if file.ChecksumSHA256 == "" {
+		if err := db.Model(file).Updates(models.File{
+			ChecksumSHA256: sums.SHA256,
+			ChecksumMD5:    sums.MD5,
+			ChecksumCRC32C: sums.CRC32C,
+		}).Error; err != nil {
+			log.Printf("Error backfilling checksums for file %d: %v", file.ID, err)
+		}
+		return n
+	}
+
+	if sums.SHA256 != file.ChecksumSHA256 || n != file.Size {
+		report.Status = models.IntegrityMismatch
+		report.ActualSHA256 = sums.SHA256
+		report.ActualSize = n
+		saveIntegrityReport(db, &report)
+		return n
+	}
+
+	// The file verifies again, so an earlier report no longer applies
+	if err := db.Unscoped().Where("file_id = ?", file.ID).Delete(&models.IntegrityReport{}).Error; err != nil {
+		log.Printf("Error clearing integrity report for file %d: %v", file.ID, err)
+	}
+	return n
+}
+
+// saveIntegrityReport replaces the file's report with the latest result
+func saveIntegrityReport(db *gorm.DB, report *models.IntegrityReport) {
+	log.Printf("Integrity check failed for file %d: %s", report.FileID, report.Status)
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "file_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "deleted_at", "status", "expected_sha256",
+			"actual_sha256", "expected_size", "actual_size", "detail", "checked_at"}),
+	}).Create(report).Error
+	if err != nil {
+		log.Printf("Error saving integrity report for file %d: %v", report.FileID, err)
+	}
+}
@@ -0,0 +1,148 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// ThumbnailSizes maps thumbnail size names to their longest side in pixels
+var ThumbnailSizes = map[string]int{
+	"small":  128,
+	"medium": 256,
+	"large":  512,
+}
+
+// maxThumbnailSource caps how much of an image we read to build thumbnails
+const maxThumbnailSource = 50 << 20
+
+// TypeThumbnails is the job type that generates a file's thumbnails
+const TypeThumbnails = "thumbnails"
+
+func init() {
+	Register(TypeThumbnails, HandlerOptions{MaxAttempts: 3, Timeout: 5 * time.Minute, OnDead: thumbnailsDead}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		fileID, err := filePayload(payload)
+		if err != nil {
+			return err
+		}
+		err = GenerateThumbnails(ctx, db, fileID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Permanent(err)
+		}
+		return err
+	})
+}
+
+// EnqueueThumbnails schedules thumbnail generation for a file
+func EnqueueThumbnails(db *gorm.DB, fileID uint) error {
+	_, err := EnqueueUnique(db, TypeThumbnails, fmt.Sprintf("%s:%d", TypeThumbnails, fileID), FilePayload{FileID: fileID})
+	return err
+}
+
+// GenerateThumbnails builds every thumbnail size for an image file and stores
+// them next to the original object. Images that are too large or cannot be
+// decoded fail permanently, since retrying cannot change the outcome.
+func GenerateThumbnails(ctx context.Context, db *gorm.DB, fileID uint) error {
+	var file models.File
+	if err := db.First(&file, fileID).Error; err != nil {
+		return err
+	}
+	if !utils.Thumbnailable(file.MimeType) {
+		return nil
+	}
+	if file.Size > maxThumbnailSource {
+		return Permanent(fmt.Errorf("image too large for thumbnailing: %d bytes", file.Size))
+	}
+
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return err
+	}
+
+	obj, err := s3Client.GetObject(ctx, utils.FileObjectKey(&file))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(obj.Body, maxThumbnailSource))
+	obj.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	img, format, err := utils.DecodeImage(data)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	for size, side := range ThumbnailSizes {
+		thumb := utils.Thumbnail(img, side)
+
+		var buf bytes.Buffer
+		contentType, err := utils.EncodeThumbnail(&buf, thumb, format)
+		if err != nil {
+			return err
+		}
+
+		key := utils.ThumbnailKey(file.ID, size)
+		if err := s3Client.PutObject(ctx, key, bytes.NewReader(buf.Bytes()), contentType, nil); err != nil {
+			return err
+		}
+
+		record := models.Thumbnail{
+			Key:         key,
+			Width:       thumb.Bounds().Dx(),
+			Height:      thumb.Bounds().Dy(),
+			ContentType: contentType,
+			Bytes:       int64(buf.Len()),
+		}
+		if err := db.Where(models.Thumbnail{FileID: file.ID, Size: size}).
+			Assign(record).FirstOrCreate(&models.Thumbnail{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// thumbnailsDead records the thumbnails step as failed, so the thumbnail
+// endpoint stops queueing a job that cannot succeed
+func thumbnailsDead(db *gorm.DB, cache utils.Cache, payload json.RawMessage, jobErr error) {
+	fileID, err := filePayload(payload)
+	if err != nil {
+		return
+	}
+	var file models.File
+	if err := db.First(&file, fileID).Error; err != nil {
+		return
+	}
+	if file.ProcessingSteps == nil {
+		file.ProcessingSteps = map[string]string{}
+	}
+	file.ProcessingSteps[models.StepThumbnails] = models.StepFailed
+	saveProcessing(db, cache, &file)
+}
+
+// DeleteThumbnails removes a file's thumbnail objects and records
+func DeleteThumbnails(ctx context.Context, db *gorm.DB, s3Client *utils.S3Client, fileID uint) error {
+	var thumbs []models.Thumbnail
+	if err := db.Where("file_id = ?", fileID).Find(&thumbs).Error; err != nil {
+		return err
+	}
+
+	for _, thumb := range thumbs {
+		if err := s3Client.DeleteObject(ctx, thumb.Key); err != nil {
+			return err
+		}
+	}
+
+	return db.Unscoped().Where("file_id = ?", fileID).Delete(&models.Thumbnail{}).Error
+}
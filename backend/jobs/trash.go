@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// TypeTrash is the scheduled job that purges files that have been in the
+// trash for longer than TRASH_RETENTION. Until then a deleted file can be restored.
+const TypeTrash = "trash"
+
+func init() {
+	Register(TypeTrash, HandlerOptions{MaxAttempts: 1, Exclusive: true}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		return emptyTrash(ctx, db, utils.EnvDuration("TRASH_RETENTION", 30*24*time.Hour))
+	})
+}
+
+func emptyTrash(ctx context.Context, db *gorm.DB, retention time.Duration) error {
+	var files []models.File
+	if err := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-retention)).
+		Limit(500).Find(&files).Error; err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return err
+	}
+
+	for i := range files {
+		// Stop if the job's lease was lost
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := PurgeFile(ctx, db, s3Client, &files[i]); err != nil {
+			log.Printf("Error purging file %d: %v", files[i].ID, err)
+			continue
+		}
+		log.Printf("Purged trashed file: %s", files[i].OriginalName)
+	}
+	return nil
+}
+
+// PurgeFile permanently removes a file: its object, thumbnails, search index,
+// metadata, tag links and finally the database row.
+func PurgeFile(ctx context.Context, db *gorm.DB, s3Client *utils.S3Client, file *models.File) error {
+	if err := s3Client.DeleteObject(ctx, utils.FileObjectKey(file)); err != nil {
+		return err
+	}
+	if err := DeleteThumbnails(ctx, db, s3Client, file.ID); err != nil {
+		return err
+	}
+	if err := DeleteIndex(db, file.ID); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("file_id = ?", file.ID).Delete(&models.FileMetadata{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM file_tags WHERE file_id = ?", file.ID).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(file).Error
+	})
+}
@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"gorm.io/gorm"
+)
+
+// TypeWebhook is the job type that delivers one event to one webhook
+const TypeWebhook = "webhook"
+
+func init() {
+	// Failed deliveries are retried with the queue's exponential backoff
+	Register(TypeWebhook, HandlerOptions{MaxAttempts: 8, Timeout: time.Minute}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		var p webhookPayload
+		if err := json.Unmarshal(payload, &p); err != nil || p.WebhookID == 0 {
+			return Permanent(fmt.Errorf("invalid webhook payload %s", payload))
+		}
+
+		var hook models.Webhook
+		if err := db.First(&hook, p.WebhookID).Error; err != nil {
+			return Permanent(err)
+		}
+		if !hook.Active {
+			return Permanent(errors.New("webhook is disabled"))
+		}
+
+		delivery := SendWebhook(ctx, db, &hook, p.Event)
+		if !delivery.Success {
+			return fmt.Errorf("delivery %d failed: %s", delivery.ID, deliveryFailure(delivery))
+		}
+		return nil
+	})
+}
+
+// WebhookEvent is the JSON body posted to webhooks
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	UserID    uint        `json:"user_id"`
+	Data      interface{} `json:"data"`
+}
+
+type webhookPayload struct {
+	WebhookID uint            `json:"webhook_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// NewWebhookEvent builds an event with a fresh ID
+func NewWebhookEvent(userID uint, eventType string, data interface{}) WebhookEvent {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return WebhookEvent{
+		ID:        "evt_" + hex.EncodeToString(b),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		UserID:    userID,
+		Data:      data,
+	}
+}
+
+// WebhookMatches reports whether a subscription filter includes an event
+func WebhookMatches(filters []string, eventType string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f == "*" || f == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(f, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhook signs a body as "t=<unix time>,v1=<hex HMAC-SHA256>", where
+// the MAC covers "<unix time>.<body>" so receivers can reject replays
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendWebhook posts an encoded event to a webhook once and logs the attempt
+func SendWebhook(ctx context.Context, db *gorm.DB, hook *models.Webhook, event json.RawMessage) *models.WebhookDelivery {
+	var meta struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	json.Unmarshal(event, &meta)
+
+	var previous int64
+	db.Model(&models.WebhookDelivery{}).Where("webhook_id = ? AND event_id = ?", hook.ID, meta.ID).Count(&previous)
+	delivery := &models.WebhookDelivery{
+		WebhookID: hook.ID,
+		EventID:   meta.ID,
+		Event:     meta.Type,
+		Payload:   event,
+		Attempt:   int(previous) + 1,
+	}
+
+	start := time.Now()
+	status, body, err := postWebhook(ctx, hook, meta.ID, meta.Type, event)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.StatusCode = status
+	delivery.ResponseBody = body
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	delivery.Success = err == nil && status >= 200 && status < 300
+
+	if err := db.Create(delivery).Error; err != nil {
+		log.Printf("Error logging delivery to webhook %d: %v", hook.ID, err)
+	}
+	return delivery
+}
+
+func postWebhook(ctx context.Context, hook *models.Webhook, eventID, eventType string, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "filesharing-webhooks/1")
+	req.Header.Set("X-Webhook-ID", eventID)
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set("X-Webhook-Signature", SignWebhook(hook.Secret, time.Now(), body))
+
+	resp, err := webhookClient().Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, strings.ToValidUTF8(string(respBody), ""), nil
+}
+
+func deliveryFailure(d *models.WebhookDelivery) string {
+	if d.Error != "" {
+		return d.Error
+	}
+	return "status " + strconv.Itoa(d.StatusCode)
+}
+
+// webhookClient is shared by all deliveries so keep-alive connections are
+// reused. It does not follow redirects and refuses link-local addresses such
+// as cloud metadata endpoints, and private and loopback addresses unless
+// WEBHOOK_ALLOW_PRIVATE is true, since responses end up in the delivery log.
+var webhookClient = sync.OnceValue(func() *http.Client {
+	allowPrivate := utils.EnvBool("WEBHOOK_ALLOW_PRIVATE", false)
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			if !allowPrivate && (ip.IsLoopback() || ip.IsPrivate()) {
+				return fmt.Errorf("webhook address %s is private", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: utils.EnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			Proxy:               nil,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+})
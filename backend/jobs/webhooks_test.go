@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookMatches(t *testing.T) {
+	tests := []struct {
+		filters   []string
+		eventType string
+		want      bool
+	}{
+		{nil, "file.uploaded", true},
+		{[]string{}, "file.uploaded", true},
+		{[]string{"*"}, "file.uploaded", true},
+		{[]string{"file.uploaded"}, "file.uploaded", true},
+		{[]string{"file.deleted"}, "file.uploaded", false},
+		{[]string{"file.*"}, "file.uploaded", true},
+		{[]string{"file.*"}, "folder.created", false},
+		{[]string{"folder.created", "file.*"}, "file.deleted", true},
+		{[]string{"file.upload"}, "file.uploaded", false},
+	}
+
+	for _, tt := range tests {
+		if got := WebhookMatches(tt.filters, tt.eventType); got != tt.want {
+			t.Errorf("WebhookMatches(%q, %q) = %v, want %v", tt.filters, tt.eventType, got, tt.want)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	tests := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		{"secret", `{"id":"evt_1"}`, "t=1700000000,v1=af784f27423c462e20039559cd4264140f7b7ed4c9090e26fd663faa5eeb8dda"},
+		{"", "", "t=1700000000,v1=c1da1b6c6b8e9da7f4bbb90f7cab0820f271ad19ccbf80c88479c4e14f37d1c6"},
+	}
+
+	for _, tt := range tests {
+		got := SignWebhook(tt.secret, ts, []byte(tt.body))
+		if got != tt.want {
+			t.Errorf("SignWebhook(%q, %q) = %q, want %q", tt.secret, tt.body, got, tt.want)
+		}
+	}
+}
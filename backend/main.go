@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"filesharing/jobs"
@@ -34,11 +35,74 @@ func initDB() (*gorm.DB, error) {
 	}
 
 	// Auto migrate the schema
-	err = db.AutoMigrate(&models.User{}, &models.File{})
+	// Integrity reports used to pile up one per scrub pass; keep the latest
+	// per file before AutoMigrate makes file_id unique
+	if db.Migrator().HasTable(&models.IntegrityReport{}) && !db.Migrator().HasIndex(&models.IntegrityReport{}, "idx_integrity_reports_file") {
+		for _, stmt := range []string{
+			`DELETE FROM integrity_reports older USING integrity_reports newer
+				WHERE older.file_id = newer.file_id AND older.id < newer.id`,
+			`DROP INDEX IF EXISTS idx_integrity_reports_file_id`,
+		} {
+			if err := db.Exec(stmt).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = db.AutoMigrate(&models.User{}, &models.File{}, &models.IntegrityReport{}, &models.ReconcileRun{}, &models.ReconcileItem{}, &models.Thumbnail{}, &models.FileMetadata{}, &models.FileContent{}, &models.Folder{}, &models.Tag{}, &models.BulkJob{}, &models.Job{}, &models.JobSchedule{}, &models.TransferUsage{}, &models.AuditEvent{}, &models.ShareAccess{}, &models.Webhook{}, &models.WebhookDelivery{}, &models.Notification{}, &models.NotificationPreference{})
+	if err != nil {
+		return nil, err
+	}
+
+	// At most one pending or running job per key
+	if err := jobs.MigrateQueue(db); err != nil {
+		return nil, err
+	}
+
+	// Tag names are unique per user regardless of case. Tags that differ only
+	// in case are merged into the oldest first.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range []string{
+			`CREATE TEMP TABLE tag_merges ON COMMIT DROP AS
+				SELECT id, first_value(id) OVER (PARTITION BY user_id, lower(name) ORDER BY id) AS keep
+				FROM tags WHERE deleted_at IS NULL`,
+			`INSERT INTO file_tags (file_id, tag_id)
+				SELECT file_tags.file_id, tag_merges.keep FROM file_tags
+				JOIN tag_merges ON tag_merges.id = file_tags.tag_id AND tag_merges.id <> tag_merges.keep
+				ON CONFLICT DO NOTHING`,
+			`DELETE FROM file_tags USING tag_merges
+				WHERE tag_merges.id = file_tags.tag_id AND tag_merges.id <> tag_merges.keep`,
+			`UPDATE tags SET deleted_at = now() FROM tag_merges
+				WHERE tag_merges.id = tags.id AND tag_merges.id <> tag_merges.keep`,
+			`DROP INDEX IF EXISTS idx_tags_user_name`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_user_lower_name ON tags (user_id, lower(name)) WHERE deleted_at IS NULL`,
+		} {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Files used to store a zero time for "never expires"
+	if err := db.Unscoped().Model(&models.File{}).Where("expires_at < ?", time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC)).
+		Update("expires_at", nil).Error; err != nil {
+		return nil, err
+	}
+
+	// Audit events can be added but never changed or removed
+	if err := utils.MakeAppendOnly(db, "audit_events"); err != nil {
+		return nil, err
+	}
+
+	// Fuzzy filename search degrades to an in-process scorer without pg_trgm
+	if err := utils.EnableTrigramSearch(db); err != nil {
+		log.Printf("Warning: trigram search unavailable, using fallback: %v", err)
+	}
+
 	return db, nil
 }
 
@@ -54,9 +118,27 @@ func main() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	// Initialize Redis
-	if err := utils.InitRedis(); err != nil {
-		log.Fatal("Failed to connect to Redis:", err)
+	// Initialize the cache; with Redis it falls back to memory while Redis is unreachable
+	cache, redisClient, redisErr := utils.NewCache()
+
+	// Leases held in an unreachable Redis would fail every exclusive job, so
+	// without Redis at startup they only coordinate this instance
+	leases := utils.NewLeaseStore(redisClient)
+	if redisErr != nil {
+		log.Printf("Warning: Redis is unreachable, job leases only cover this instance")
+		leases = utils.NewLocalLeases()
+	}
+	limiter := utils.NewRateLimiter(redisClient)
+
+	// Live change events reach every instance through Redis pub/sub
+	events := utils.NewEventBus(redisClient)
+	jobs.SetEventBus(events)
+
+	// Expiry warnings and notification digests go out through SMTP or the log
+	jobs.SetMailer(utils.NewMailer())
+
+	if os.Getenv("PUBLIC_BASE_URL") == "" {
+		log.Printf("Warning: PUBLIC_BASE_URL is not set, share links will point at %s", utils.PublicBaseURL())
 	}
 
 	// Create uploads directory if it doesn't exist
@@ -67,25 +149,39 @@ func main() {
 	// Initialize Gin router
 	r := gin.Default()
 
+	// Only trust X-Forwarded-For from known proxies, since rate limits are keyed by client IP
+	var trustedProxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		trustedProxies = strings.Split(v, ",")
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// CORS configuration
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:3000"} // Specific origin instead of wildcard
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "Last-Event-ID"}
+	config.ExposeHeaders = []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"}
 	config.AllowCredentials = true
 	config.MaxAge = 12 * time.Hour
 
 	// CORS middleware
 	r.Use(cors.New(config))
 
+	// Record an audit event for every API request, including rejected ones
+	r.Use(middleware.AuditMiddleware(db, auditActions))
+
 	// Serve static files from uploads directory
 	r.Static("/uploads", "./uploads")
 
 	// Initialize routes
-	initializeRoutes(r, db)
+	initializeRoutes(r, db, cache, limiter, events)
 
-	// Start cleanup job
-	jobs.StartCleanupJob(db)
+	// Start the background job queue; thumbnails, indexing, bulk operations
+	// and the periodic cleanup, scrub, reconcile and trash jobs all run on it
+	jobs.StartQueue(db, cache, leases)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -99,7 +195,11 @@ func main() {
 	}
 }
 
-func initializeRoutes(r *gin.Engine, db *gorm.DB) {
+func initializeRoutes(r *gin.Engine, db *gorm.DB, cache utils.Cache, limiter utils.RateLimiter, events utils.EventBus) {
+	limit := func(class string) gin.HandlerFunc {
+		return middleware.RateLimitMiddleware(limiter, class)
+	}
+
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -109,6 +209,7 @@ func initializeRoutes(r *gin.Engine, db *gorm.DB) {
 
 	// Auth routes
 	auth := r.Group("/auth")
+	auth.Use(limit(middleware.RateClassAuth))
 	{
 		auth.POST("/register", routes.Register(db))
 		auth.POST("/login", routes.Login(db))
@@ -118,17 +219,204 @@ func initializeRoutes(r *gin.Engine, db *gorm.DB) {
 	api := r.Group("/api")
 	{
 		// Public route for accessing shared files
-		api.GET("/files/shared/:token", routes.GetSharedFile(db))
+		shared := api.Group("")
+		shared.Use(limit(middleware.RateClassShared))
+		{
+			shared.GET("/files/shared/:token", routes.GetSharedFile(db))
+			shared.GET("/files/shared/:token/download", routes.DownloadSharedFile(db))
+			shared.GET("/folders/shared/:token", routes.GetSharedFolder(db))
+			shared.GET("/folders/shared/:token/download", routes.DownloadSharedFolder(db))
+		}
 
 		// Protected file routes
 		files := api.Group("/files")
-		files.Use(middleware.AuthMiddleware())
+		files.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
 		{
-			files.POST("/upload", routes.UploadFile(db))
-			files.GET("", routes.ListFiles(db))
-			files.GET("/search", routes.SearchFiles(db))
+			files.POST("/upload", limit(middleware.RateClassUpload), routes.UploadFile(db, cache))
+			files.GET("", routes.ListFiles(db, cache))
+			files.GET("/search", limit(middleware.RateClassSearch), routes.SearchFiles(db))
+			files.GET("/search/content", limit(middleware.RateClassSearch), routes.SearchContent(db))
+			files.GET("/search/fuzzy", limit(middleware.RateClassSearch), routes.FuzzySearchFiles(db))
 			files.GET("/share/:file_id", routes.ShareFile(db))
-			files.DELETE("/:file_id", routes.DeleteFile(db))
+			files.GET("/:file_id/download", limit(middleware.RateClassDownload), routes.DownloadFile(db))
+			files.GET("/:file_id/thumbnail", routes.GetThumbnail(db))
+			files.GET("/:file_id/preview", routes.PreviewFile(db))
+			files.GET("/:file_id/metadata", routes.GetFileMetadata(db))
+			files.GET("/:file_id/status", routes.GetFileStatus(db))
+			files.GET("/metadata/search", limit(middleware.RateClassSearch), routes.SearchImageMetadata(db))
+			files.PUT("/:file_id/share-settings", routes.UpdateShareSettings(db, cache))
+			files.GET("/:file_id/share-stats", routes.GetFileShareStats(db))
+			files.PUT("/:file_id/custom-metadata", routes.UpdateCustomMetadata(db, cache))
+			files.PUT("/:file_id/expiry", routes.UpdateFileExpiry(db, cache))
+			files.POST("/tags", routes.TagFiles(db, cache))
+			files.POST("/tags/remove", routes.UntagFiles(db, cache))
+			files.POST("/bulk", routes.BulkFiles(db, cache))
+			files.GET("/bulk/:job_id", routes.GetBulkJob(db))
+			files.GET("/trash", routes.ListTrash(db))
+			files.GET("/archive", limit(middleware.RateClassDownload), routes.DownloadArchive(db))
+			files.POST("/:file_id/extract", routes.ExtractArchive(db, cache))
+			files.POST("/:file_id/share/send", routes.SendFileShare(db))
+			files.PUT("/:file_id", limit(middleware.RateClassUpload), routes.ReplaceFile(db, cache))
+			files.DELETE("/:file_id", routes.DeleteFile(db, cache))
+		}
+
+		// Folder routes
+		folders := api.Group("/folders")
+		folders.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
+		{
+			folders.POST("", routes.CreateFolder(db, cache))
+			folders.GET("", routes.ListFolders(db, cache))
+			folders.POST("/:folder_id/share", routes.ShareFolder(db))
+			folders.DELETE("/:folder_id/share", routes.UnshareFolder(db))
+			folders.POST("/:folder_id/share/send", routes.SendFolderShare(db))
+			folders.GET("/:folder_id/share-stats", routes.GetFolderShareStats(db))
+		}
+
+		// Current user settings
+		users := api.Group("/users")
+		users.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
+		{
+			users.GET("/me", routes.GetCurrentUser(db))
+			users.PATCH("/me", routes.UpdateSettings(db))
+			users.GET("/me/usage", routes.GetUsage(db))
+		}
+
+		// Audit log of the user's actions and of access to their data
+		audit := api.Group("/audit")
+		audit.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
+		{
+			audit.GET("", routes.ListAuditEvents(db))
+			audit.GET("/export", routes.ExportAuditEvents(db))
+		}
+
+		// Live change events as Server-Sent Events
+		stream := api.Group("/events")
+		{
+			stream.POST("/ticket", middleware.AuthMiddleware(), limit(middleware.RateClassAPI), routes.CreateEventTicket(cache))
+			stream.GET("", middleware.StreamAuthMiddleware(cache), limit(middleware.RateClassAPI), routes.StreamEvents(events))
+			stream.GET("/files/:file_id", middleware.StreamAuthMiddleware(cache), limit(middleware.RateClassAPI), routes.StreamFileStatus(db, events))
+		}
+
+		// Webhook subscriptions and their delivery log
+		webhooks := api.Group("/webhooks")
+		webhooks.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
+		{
+			webhooks.POST("", routes.CreateWebhook(db))
+			webhooks.GET("", routes.ListWebhooks(db))
+			webhooks.GET("/:webhook_id", routes.GetWebhook(db))
+			webhooks.PATCH("/:webhook_id", routes.UpdateWebhook(db))
+			webhooks.DELETE("/:webhook_id", routes.DeleteWebhook(db))
+			webhooks.POST("/:webhook_id/test", routes.TestWebhook(db))
+			webhooks.GET("/:webhook_id/deliveries", routes.ListWebhookDeliveries(db))
+		}
+
+		// In-app notifications and their per-type channels
+		notifications := api.Group("/notifications")
+		notifications.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
+		{
+			notifications.GET("", routes.ListNotifications(db))
+			notifications.POST("/read", routes.MarkNotificationsRead(db))
+			notifications.PATCH("/:notification_id", routes.UpdateNotification(db))
+			notifications.GET("/preferences", routes.GetNotificationPreferences(db))
+			notifications.PUT("/preferences", routes.UpdateNotificationPreferences(db))
+		}
+
+		// Tag routes
+		tags := api.Group("/tags")
+		tags.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI))
+		{
+			tags.POST("", routes.CreateTag(db))
+			tags.GET("", routes.ListTags(db))
+			tags.PATCH("/:tag_id", routes.UpdateTag(db, cache))
+			tags.DELETE("/:tag_id", routes.DeleteTag(db, cache))
+		}
+
+		// Admin routes
+		admin := api.Group("/admin")
+		admin.Use(middleware.AuthMiddleware(), limit(middleware.RateClassAPI), middleware.AdminMiddleware(db))
+		{
+			admin.GET("/jobs", routes.ListJobs(db))
+			admin.GET("/jobs/stats", routes.JobStats(db))
+			admin.GET("/jobs/:job_id", routes.GetJob(db))
+			admin.POST("/jobs/:job_id/retry", routes.RetryJob(db))
+			admin.GET("/usage", routes.AdminUsage(db))
+			admin.GET("/audit", routes.AdminListAuditEvents(db))
+			admin.GET("/audit/export", routes.AdminExportAuditEvents(db))
 		}
 	}
 }
+
+// auditActions names the audit event of every API route
+var auditActions = map[string]string{
+	"POST /auth/register": models.AuditRegister,
+	"POST /auth/login":    models.AuditLogin,
+
+	"GET /api/files/shared/:token":            models.AuditShareAccess,
+	"GET /api/files/shared/:token/download":   models.AuditShareAccess,
+	"GET /api/folders/shared/:token":          models.AuditShareAccess,
+	"GET /api/folders/shared/:token/download": models.AuditShareAccess,
+
+	"POST /api/files/upload":                    models.AuditUpload,
+	"GET /api/files":                            models.AuditList,
+	"GET /api/files/search":                     models.AuditSearch,
+	"GET /api/files/search/content":             models.AuditSearch,
+	"GET /api/files/search/fuzzy":               models.AuditSearch,
+	"GET /api/files/share/:file_id":             models.AuditShareCreate,
+	"GET /api/files/:file_id/download":          models.AuditDownload,
+	"GET /api/files/:file_id/thumbnail":         models.AuditPreview,
+	"GET /api/files/:file_id/preview":           models.AuditPreview,
+	"GET /api/files/:file_id/status":            models.AuditView,
+	"GET /api/files/:file_id/metadata":          models.AuditView,
+	"GET /api/files/metadata/search":            models.AuditSearch,
+	"PUT /api/files/:file_id/share-settings":    models.AuditPermissionChange,
+	"GET /api/files/:file_id/share-stats":       models.AuditView,
+	"PUT /api/files/:file_id/custom-metadata":   models.AuditMetadataUpdate,
+	"PUT /api/files/:file_id/expiry":            models.AuditExpiryUpdate,
+	"POST /api/files/tags":                      models.AuditTag,
+	"POST /api/files/tags/remove":               models.AuditUntag,
+	"POST /api/files/bulk":                      models.AuditBulk,
+	"GET /api/files/bulk/:job_id":               models.AuditView,
+	"GET /api/files/trash":                      models.AuditList,
+	"GET /api/files/archive":                    models.AuditDownload,
+	"POST /api/files/:file_id/extract":          models.AuditExtract,
+	"POST /api/files/:file_id/share/send":       models.AuditShareSend,
+	"PUT /api/files/:file_id":                   models.AuditReplace,
+	"DELETE /api/files/:file_id":                models.AuditDelete,
+	"POST /api/folders":                         models.AuditFolderCreate,
+	"GET /api/folders":                          models.AuditList,
+	"POST /api/folders/:folder_id/share":        models.AuditShareCreate,
+	"POST /api/folders/:folder_id/share/send":   models.AuditShareSend,
+	"DELETE /api/folders/:folder_id/share":      models.AuditShareRevoke,
+	"GET /api/folders/:folder_id/share-stats":   models.AuditView,
+	"GET /api/users/me":                         models.AuditView,
+	"PATCH /api/users/me":                       models.AuditSettingsChange,
+	"GET /api/users/me/usage":                   models.AuditView,
+	"GET /api/audit":                            models.AuditView,
+	"GET /api/audit/export":                     models.AuditView,
+	"POST /api/events/ticket":                   models.AuditView,
+	"GET /api/events/files/:file_id":            models.AuditView,
+	"GET /api/events":                           models.AuditView,
+	"POST /api/webhooks":                        models.AuditWebhookCreate,
+	"GET /api/webhooks":                         models.AuditList,
+	"GET /api/webhooks/:webhook_id":             models.AuditView,
+	"PATCH /api/webhooks/:webhook_id":           models.AuditWebhookUpdate,
+	"DELETE /api/webhooks/:webhook_id":          models.AuditWebhookDelete,
+	"POST /api/webhooks/:webhook_id/test":       models.AuditWebhookTest,
+	"GET /api/webhooks/:webhook_id/deliveries":  models.AuditList,
+	"GET /api/notifications":                    models.AuditList,
+	"POST /api/notifications/read":              models.AuditNotificationRead,
+	"PATCH /api/notifications/:notification_id": models.AuditNotificationRead,
+	"GET /api/notifications/preferences":        models.AuditView,
+	"PUT /api/notifications/preferences":        models.AuditSettingsChange,
+	"POST /api/tags":                            models.AuditTagCreate,
+	"GET /api/tags":                             models.AuditList,
+	"PATCH /api/tags/:tag_id":                   models.AuditTagUpdate,
+	"DELETE /api/tags/:tag_id":                  models.AuditTagDelete,
+	"GET /api/admin/jobs":                       models.AuditView,
+	"GET /api/admin/jobs/stats":                 models.AuditView,
+	"GET /api/admin/jobs/:job_id":               models.AuditView,
+	"POST /api/admin/jobs/:job_id/retry":        models.AuditJobRetry,
+	"GET /api/admin/usage":                      models.AuditView,
+	"GET /api/admin/audit":                      models.AuditView,
+	"GET /api/admin/audit/export":               models.AuditView,
+}
@@ -40,4 +40,30 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		c.Next()
 	}
-} 
\ No newline at end of file
+}
+
+// StreamAuthMiddleware authenticates like AuthMiddleware, or with a ticket
+// from utils.NewStreamTicket in the ticket query parameter for clients that
+// cannot set the Authorization header
+func StreamAuthMiddleware(cache utils.Cache) gin.HandlerFunc {
+	bearer := AuthMiddleware()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			bearer(c)
+			return
+		}
+
+		userID, email, err := utils.RedeemStreamTicket(c.Request.Context(), cache, ticket)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", userID)
+		c.Set("email", email)
+
+		c.Next()
+	}
+}
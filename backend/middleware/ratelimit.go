@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Route classes with their own limits. Each is configured with
+// RATE_LIMIT_<CLASS> such as "60/1m", with an optional RATE_LIMIT_<CLASS>_BURST.
+const (
+	RateClassAPI      = "api"
+	RateClassAuth     = "auth"
+	RateClassUpload   = "upload"
+	RateClassDownload = "download"
+	RateClassSearch   = "search"
+	RateClassShared   = "shared"
+)
+
+var defaultRateLimits = map[string]utils.RateLimit{
+	RateClassAPI:      {Rate: 600, Period: time.Minute, Burst: 600},
+	RateClassAuth:     {Rate: 10, Period: time.Minute, Burst: 10},
+	RateClassUpload:   {Rate: 60, Period: time.Hour, Burst: 20},
+	RateClassDownload: {Rate: 300, Period: time.Minute, Burst: 300},
+	RateClassSearch:   {Rate: 60, Period: time.Minute, Burst: 60},
+	RateClassShared:   {Rate: 120, Period: time.Minute, Burst: 120},
+}
+
+// RateLimitMiddleware limits requests per class for each user, or per client
+// IP when there is none. Authentication routes and shared links are always
+// limited by IP. Responses carry RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, plus Retry-After on 429.
+func RateLimitMiddleware(limiter utils.RateLimiter, class string) gin.HandlerFunc {
+	limit, enabled := utils.ParseRateLimit("RATE_LIMIT_"+strings.ToUpper(class), defaultRateLimits[class])
+	if !utils.EnvBool("RATE_LIMIT_ENABLED", true) {
+		enabled = false
+	}
+	policy := fmt.Sprintf("%d;w=%d", limit.Burst, int64(math.Ceil(limit.Period.Seconds())))
+
+	return func(c *gin.Context) {
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		key := fmt.Sprintf("ratelimit:%s:%s", class, rateLimitIdentity(c, class))
+		result, err := limiter.Allow(c.Request.Context(), key, limit)
+		if err != nil {
+			// Never turn a limiter failure into an outage
+			log.Printf("Error checking rate limit %s: %v", key, err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		c.Header("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		c.Header("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.ResetAfter), 10))
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitIdentity picks who a request is counted against
+func rateLimitIdentity(c *gin.Context, class string) string {
+	if class != RateClassAuth && class != RateClassShared {
+		if userID, ok := c.Get("userID"); ok {
+			return fmt.Sprintf("user:%v", userID)
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit actions. Every API route maps to one of these.
+const (
+	AuditRegister         = "register"
+	AuditLogin            = "login"
+	AuditUpload           = "upload"
+	AuditReplace          = "replace"
+	AuditDownload         = "download"
+	AuditPreview          = "preview"
+	AuditList             = "list"
+	AuditSearch           = "search"
+	AuditView             = "view"
+	AuditShareCreate      = "share-create"
+	AuditShareRevoke      = "share-revoke"
+	AuditShareAccess      = "share-access"
+	AuditDelete           = "delete"
+	AuditBulk             = "bulk"
+	AuditExtract          = "extract"
+	AuditTag              = "tag"
+	AuditUntag            = "untag"
+	AuditTagCreate        = "tag-create"
+	AuditTagUpdate        = "tag-update"
+	AuditTagDelete        = "tag-delete"
+	AuditFolderCreate     = "folder-create"
+	AuditMetadataUpdate   = "metadata-update"
+	AuditExpiryUpdate     = "expiry-update"
+	AuditPermissionChange = "permission-change"
+	AuditSettingsChange   = "settings-change"
+	AuditJobRetry         = "job-retry"
+	AuditWebhookCreate    = "webhook-create"
+	AuditWebhookUpdate    = "webhook-update"
+	AuditWebhookDelete    = "webhook-delete"
+	AuditWebhookTest      = "webhook-test"
+	AuditShareSend        = "share-send"
+	AuditNotificationRead = "notification-read"
+)
+
+// Audit results
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied"
+	AuditFailure = "failure"
+)
+
+// AuditEvent records one request against the API. The table is append-only:
+// a trigger rejects updates and deletes. OwnerID is the user whose data was
+// touched, so owners can see anonymous access through their share links.
+type AuditEvent struct {
+	ID         uint                   `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time              `gorm:"index" json:"created_at"`
+	ActorID    *uint                  `gorm:"index" json:"actor_id,omitempty"`
+	OwnerID    *uint                  `gorm:"index" json:"owner_id,omitempty"`
+	Action     string                 `gorm:"size:32;index;not null" json:"action"`
+	TargetType string                 `gorm:"size:32;index:idx_audit_events_target" json:"target_type,omitempty"`
+	TargetID   string                 `gorm:"size:64;index:idx_audit_events_target" json:"target_id,omitempty"`
+	IP         string                 `gorm:"size:64" json:"ip"`
+	UserAgent  string                 `gorm:"size:512" json:"user_agent"`
+	Result     string                 `gorm:"size:16;not null" json:"result"`
+	Status     int                    `json:"status"`
+	Details    map[string]interface{} `gorm:"serializer:json;type:jsonb" json:"details,omitempty"`
+}
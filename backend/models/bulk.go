@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// States of a background bulk operation
+const (
+	BulkQueued    = "queued"
+	BulkRunning   = "running"
+	BulkCompleted = "completed"
+	BulkFailed    = "failed"
+)
+
+// BulkResult is the outcome of a bulk operation for one file. Archive
+// extraction also records the path of the entry the file came from.
+type BulkResult struct {
+	FileID   uint   `json:"file_id"`
+	Path     string `json:"path,omitempty"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	ShareURL string `json:"share_url,omitempty"`
+}
+
+// BulkJob tracks a bulk operation too large to run within the request
+type BulkJob struct {
+	gorm.Model
+	UserID     uint         `gorm:"index;not null" json:"user_id"`
+	Action     string       `gorm:"not null" json:"action"`
+	Status     string       `gorm:"index;not null" json:"status"`
+	Total      int          `json:"total"`
+	Processed  int          `json:"processed"`
+	Succeeded  int          `json:"succeeded"`
+	Failed     int          `json:"failed"`
+	Params     []byte       `gorm:"type:jsonb" json:"-"`
+	Results    []BulkResult `gorm:"serializer:json;type:jsonb" json:"results,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
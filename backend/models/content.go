@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FileContent holds the text extracted from a document for full-text search.
+// SearchVector is maintained with raw SQL by the indexer.
+type FileContent struct {
+	gorm.Model
+	FileID       uint      `gorm:"uniqueIndex;not null" json:"file_id"`
+	Content      string    `gorm:"type:text" json:"-"`
+	SearchVector string    `gorm:"->;type:tsvector;index:idx_file_contents_search_vector,type:gin" json:"-"`
+	IndexedAt    time.Time `json:"indexed_at"`
+}
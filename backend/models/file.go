@@ -6,16 +6,71 @@ import (
 	"gorm.io/gorm"
 )
 
+// Processing states of a file. An upload starts as uploaded, is scanned for
+// malware, then has its thumbnails and search index built, and ends ready
+// or failed. A replacement starts over.
+const (
+	ProcessingUploaded   = "uploaded"
+	ProcessingScanning   = "scanning"
+	ProcessingProcessing = "processing"
+	ProcessingReady      = "ready"
+	ProcessingFailed     = "failed"
+)
+
+// Processing steps and their states in File.ProcessingSteps
+const (
+	StepScan       = "scan"
+	StepThumbnails = "thumbnails"
+	StepIndex      = "index"
+
+	StepPending  = "pending"
+	StepDone     = "done"
+	StepSkipped  = "skipped"
+	StepFailed   = "failed"
+	StepInfected = "infected"
+)
+
 type File struct {
 	gorm.Model
-	UserID       uint      `gorm:"not null" json:"user_id"`
-	User         *User     `gorm:"foreignKey:UserID" json:"-"`
-	Filename     string    `gorm:"not null" json:"filename"`
-	OriginalName string    `gorm:"not null" json:"original_name"`
-	Size         int64     `gorm:"not null" json:"size"`
-	MimeType     string    `gorm:"not null" json:"mime_type"`
-	ExpiresAt    time.Time `json:"expires_at,omitempty"`
-	IsPublic     bool      `gorm:"default:false" json:"is_public"`
-	ShareToken   string    `gorm:"uniqueIndex" json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	UserID       uint       `gorm:"not null" json:"user_id"`
+	User         *User      `gorm:"foreignKey:UserID" json:"-"`
+	FolderID     *uint      `gorm:"index" json:"folder_id,omitempty"`
+	Folder       *Folder    `gorm:"foreignKey:FolderID" json:"-"`
+	Filename     string     `gorm:"not null" json:"filename"`
+	OriginalName string     `gorm:"not null" json:"original_name"`
+	Size         int64      `gorm:"not null" json:"size"`
+	MimeType     string     `gorm:"not null" json:"mime_type"`
+	ExpiresAt    *time.Time `gorm:"index" json:"expires_at,omitempty"`
+	IsPublic     bool       `gorm:"default:false" json:"is_public"`
+	ShareToken   string     `gorm:"uniqueIndex" json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	// S3 key of the content; rows from before it was recorded derive it from
+	// CreatedAt, see utils.FileObjectKey
+	ObjectKey string `gorm:"size:512" json:"-"`
+
+	// Hex encoded digests computed at upload, used for download headers and scrubbing
+	ChecksumSHA256 string `gorm:"size:64" json:"checksum_sha256,omitempty"`
+	ChecksumMD5    string `gorm:"size:32" json:"checksum_md5,omitempty"`
+	ChecksumCRC32C string `gorm:"size:8" json:"checksum_crc32c,omitempty"`
+
+	// Set once the owner has been warned that the file is about to expire
+	ExpiryWarnedAt *time.Time `json:"-"`
+
+	// Per-share override of the owner's StripSharedMetadata setting
+	StripMetadata *bool `json:"strip_metadata,omitempty"`
+
+	// Where background processing stands; files from before it existed are ready
+	ProcessingStatus string            `gorm:"size:16;not null;default:'ready';index" json:"processing_status"`
+	ProcessingSteps  map[string]string `gorm:"serializer:json;type:jsonb" json:"processing_steps,omitempty"`
+	ProcessingError  string            `gorm:"size:512" json:"processing_error,omitempty"`
+	ProcessedAt      *time.Time        `json:"processed_at,omitempty"`
+
+	Tags           []Tag                  `gorm:"many2many:file_tags;" json:"tags,omitempty"`
+	CustomMetadata map[string]interface{} `gorm:"serializer:json;type:jsonb" json:"custom_metadata,omitempty"`
+}
+
+// Quarantined reports whether the malware scan flagged the file
+func (f *File) Quarantined() bool {
+	return f.ProcessingSteps[StepScan] == StepInfected
 }
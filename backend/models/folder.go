@@ -0,0 +1,19 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Folder groups a user's files. Path is the materialized /parent/child path
+// used for prefix lookups such as the in: search filter.
+type Folder struct {
+	gorm.Model
+	UserID   uint    `gorm:"not null;uniqueIndex:idx_folders_user_path" json:"user_id"`
+	ParentID *uint   `gorm:"index" json:"parent_id,omitempty"`
+	Parent   *Folder `gorm:"foreignKey:ParentID" json:"-"`
+	Name     string  `gorm:"not null" json:"name"`
+	Path     string  `gorm:"not null;uniqueIndex:idx_folders_user_path" json:"path"`
+
+	// ShareToken exposes the folder and everything below it read-only
+	ShareToken *string `gorm:"uniqueIndex" json:"-"`
+}
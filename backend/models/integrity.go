@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Integrity report statuses recorded by the scrub job
+const (
+	IntegrityMismatch = "mismatch"
+	IntegrityMissing  = "missing"
+)
+
+// IntegrityReport records a file whose stored object failed its latest
+// verification; each file has at most one
+type IntegrityReport struct {
+	gorm.Model
+	FileID         uint      `gorm:"uniqueIndex:idx_integrity_reports_file;not null" json:"file_id"`
+	Status         string    `gorm:"index;not null" json:"status"`
+	ExpectedSHA256 string    `gorm:"size:64" json:"expected_sha256"`
+	ActualSHA256   string    `gorm:"size:64" json:"actual_sha256,omitempty"`
+	ExpectedSize   int64     `json:"expected_size"`
+	ActualSize     int64     `json:"actual_size"`
+	Detail         string    `json:"detail,omitempty"`
+	CheckedAt      time.Time `gorm:"not null" json:"checked_at"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// States of a queued background job. Failed attempts go back to pending with
+// a later RunAt until MaxAttempts is reached, after which the job is dead and
+// kept for inspection and manual retry.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Job is a unit of work in the durable background queue
+type Job struct {
+	gorm.Model
+	Type        string          `gorm:"index;not null" json:"type"`
+	Key         string          `gorm:"index" json:"key,omitempty"`
+	Payload     json.RawMessage `gorm:"type:jsonb" json:"payload"`
+	Status      string          `gorm:"index:idx_jobs_status_run_at;not null" json:"status"`
+	RunAt       time.Time       `gorm:"index:idx_jobs_status_run_at;not null" json:"run_at"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LockedBy    string          `json:"locked_by,omitempty"`
+	LockedAt    *time.Time      `json:"locked_at,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// JobSchedule enqueues a job of JobType every Interval. Only the instance
+// holding the scheduler lease enqueues runs; Fence records the lease token of
+// the last enqueue so a deposed leader cannot claim a run after a newer one.
+type JobSchedule struct {
+	Name            string    `gorm:"primaryKey" json:"name"`
+	JobType         string    `gorm:"not null" json:"job_type"`
+	IntervalSeconds int64     `json:"interval_seconds"`
+	NextRunAt       time.Time `json:"next_run_at"`
+	Fence           int64     `json:"fence"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FileMetadata holds metadata extracted from an image at upload
+type FileMetadata struct {
+	gorm.Model
+	FileID      uint       `gorm:"uniqueIndex;not null" json:"file_id"`
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	CameraMake  string     `gorm:"index" json:"camera_make,omitempty"`
+	CameraModel string     `gorm:"index" json:"camera_model,omitempty"`
+	TakenAt     *time.Time `gorm:"index" json:"taken_at,omitempty"`
+	Orientation int        `json:"orientation,omitempty"`
+	HasGPS      bool       `gorm:"index" json:"has_gps"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+}
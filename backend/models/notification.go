@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// Notification types
+const (
+	NotifyShareReceived    = "share_received"
+	NotifyFileExpiring     = "file_expiring"
+	NotifyFileExpired      = "file_expired"
+	NotifyProcessingFailed = "processing_failed"
+)
+
+// NotificationTypes lists every notification type
+var NotificationTypes = []string{NotifyShareReceived, NotifyFileExpiring, NotifyFileExpired, NotifyProcessingFailed}
+
+// Notification is a message to a user. InApp and Email record the channels
+// the user's preferences chose when it was created: in-app notifications
+// are listed and pushed live, email ones go out in the daily digest, except
+// expiry warnings which are emailed right away.
+type Notification struct {
+	ID        uint                   `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time              `gorm:"index" json:"created_at"`
+	UserID    uint                   `gorm:"index:idx_notifications_user;not null" json:"user_id"`
+	Type      string                 `gorm:"size:32;not null" json:"type"`
+	Title     string                 `gorm:"size:255;not null" json:"title"`
+	Body      string                 `gorm:"size:2048" json:"body,omitempty"`
+	Link      string                 `gorm:"size:2048" json:"link,omitempty"`
+	Data      map[string]interface{} `gorm:"serializer:json;type:jsonb" json:"data,omitempty"`
+	ReadAt    *time.Time             `gorm:"index:idx_notifications_user" json:"read_at"`
+	InApp     bool                   `gorm:"not null" json:"-"`
+	Email     bool                   `gorm:"not null" json:"-"`
+	EmailedAt *time.Time             `json:"emailed_at,omitempty"`
+}
+
+// NotificationPreference overrides the default channels of one type for a user
+type NotificationPreference struct {
+	UserID uint   `gorm:"primaryKey" json:"-"`
+	Type   string `gorm:"primaryKey;size:32" json:"type"`
+	InApp  bool   `gorm:"not null" json:"in_app"`
+	Email  bool   `gorm:"not null" json:"email"`
+}
+
+// DefaultNotificationPreference applies to types a user has not configured.
+// Everything shows in the app; only expiry warnings are emailed.
+func DefaultNotificationPreference(userID uint, notificationType string) NotificationPreference {
+	return NotificationPreference{
+		UserID: userID,
+		Type:   notificationType,
+		InApp:  true,
+		Email:  notificationType == NotifyFileExpiring,
+	}
+}
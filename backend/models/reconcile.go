@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of discrepancy found by the reconcile job
+const (
+	ReconcileOrphan = "orphan" // object in storage with no file row
+	ReconcileGhost  = "ghost"  // file row whose object is missing
+)
+
+// ReconcileRun summarises one pass of the storage/database reconcile job
+type ReconcileRun struct {
+	gorm.Model
+	Policy     string          `gorm:"not null" json:"policy"`
+	DryRun     bool            `json:"dry_run"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Orphans    int             `json:"orphans"`
+	Ghosts     int             `json:"ghosts"`
+	Repaired   int             `json:"repaired"`
+	Error      string          `json:"error,omitempty"`
+	Items      []ReconcileItem `gorm:"foreignKey:RunID" json:"items,omitempty"`
+}
+
+// ReconcileItem is a single orphan object or ghost row found during a run
+type ReconcileItem struct {
+	gorm.Model
+	RunID    uint   `gorm:"index;not null" json:"run_id"`
+	Kind     string `gorm:"index;not null" json:"kind"`
+	Key      string `gorm:"not null" json:"key"`
+	FileID   *uint  `json:"file_id,omitempty"`
+	Size     int64  `json:"size"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+}
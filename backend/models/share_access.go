@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// Kinds of shared-link access
+const (
+	ShareView     = "view"
+	ShareDownload = "download"
+)
+
+// ShareAccess records one request to a share link. The link is stored as a
+// hash so the table cannot be used to recover working links, and the
+// visitor's IP only as a keyed hash that still counts unique visitors.
+type ShareAccess struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+	LinkHash    string    `gorm:"size:64;index;not null" json:"link"`
+	FileID      *uint     `gorm:"index" json:"file_id,omitempty"`
+	FolderID    *uint     `gorm:"index" json:"folder_id,omitempty"`
+	OwnerID     *uint     `gorm:"index" json:"-"`
+	Kind        string    `gorm:"size:16;not null" json:"kind"`
+	VisitorHash string    `gorm:"size:64" json:"visitor"`
+	UserAgent   string    `gorm:"size:512" json:"user_agent"`
+	Referrer    string    `gorm:"size:1024" json:"referrer,omitempty"`
+	BytesServed int64     `json:"bytes_served"`
+	Status      int       `json:"status"`
+	Denied      string    `gorm:"size:32" json:"denied,omitempty"` // reason the request was refused
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Tag is a user-defined label that can be attached to many files. Names are
+// unique per user regardless of case, enforced by idx_tags_user_lower_name.
+type Tag struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index" json:"user_id"`
+	Name   string `gorm:"not null" json:"name"`
+	Color  string `gorm:"size:7" json:"color"`
+	Files  []File `gorm:"many2many:file_tags;" json:"-"`
+}
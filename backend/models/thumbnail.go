@@ -0,0 +1,17 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Thumbnail is a scaled-down copy of an image file stored as a derived object
+type Thumbnail struct {
+	gorm.Model
+	FileID      uint   `gorm:"uniqueIndex:idx_thumbnail_file_size;not null" json:"file_id"`
+	Size        string `gorm:"uniqueIndex:idx_thumbnail_file_size;not null" json:"size"`
+	Key         string `gorm:"not null" json:"-"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `gorm:"not null" json:"content_type"`
+	Bytes       int64  `json:"bytes"`
+}
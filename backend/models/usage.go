@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// TransferUsage counts the bytes moved for one user on one UTC day.
+// SharedBytes are downloads of the user's files through share links.
+type TransferUsage struct {
+	ID              uint      `gorm:"primarykey" json:"-"`
+	UserID          uint      `gorm:"not null;uniqueIndex:idx_transfer_usage_user_day" json:"user_id"`
+	Day             time.Time `gorm:"type:date;not null;uniqueIndex:idx_transfer_usage_user_day;index" json:"day"`
+	UploadedBytes   int64     `gorm:"not null;default:0" json:"uploaded_bytes"`
+	DownloadedBytes int64     `gorm:"not null;default:0" json:"downloaded_bytes"`
+	SharedBytes     int64     `gorm:"not null;default:0" json:"shared_bytes"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -10,4 +10,13 @@ type User struct {
 	Email    string `gorm:"uniqueIndex;not null" json:"email"`
 	Password string `gorm:"not null" json:"-"`
 	Files    []File `gorm:"foreignKey:UserID" json:"files,omitempty"`
+
+	// Serve shared images with EXIF/GPS metadata removed unless a file overrides it
+	StripSharedMetadata bool `gorm:"default:false" json:"strip_shared_metadata"`
+
+	// Expiry applied to new uploads that do not set one; 0 keeps files forever
+	DefaultExpirySeconds int64 `gorm:"default:0" json:"default_expiry_seconds"`
+
+	// Grants access to the /api/admin endpoints; set directly in the database
+	IsAdmin bool `gorm:"default:false" json:"is_admin"`
 }
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook events
+const (
+	EventFileUploaded   = "file.uploaded"
+	EventFileReplaced   = "file.replaced"
+	EventFileUpdated    = "file.updated"
+	EventFileDeleted    = "file.deleted"
+	EventFileRestored   = "file.restored"
+	EventFileShared     = "file.shared"
+	EventFolderShared   = "folder.shared"
+	EventFolderUnshared = "folder.unshared"
+	EventWebhookTest    = "webhook.test"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to
+var WebhookEvents = []string{
+	EventFileUploaded, EventFileReplaced, EventFileUpdated, EventFileDeleted, EventFileRestored,
+	EventFileShared, EventFolderShared, EventFolderUnshared,
+}
+
+// Webhook posts a user's events to URL. An empty Events list subscribes to
+// everything; entries may end in ".*" to match a group such as "file.*".
+// Payloads are signed with Secret, which is only shown when it is created.
+type Webhook struct {
+	gorm.Model
+	UserID      uint     `gorm:"index;not null" json:"user_id"`
+	URL         string   `gorm:"size:2048;not null" json:"url"`
+	Description string   `gorm:"size:255" json:"description,omitempty"`
+	Events      []string `gorm:"serializer:json;type:jsonb" json:"events"`
+	Secret      string   `gorm:"size:64;not null" json:"-"`
+	Active      bool     `gorm:"not null;default:true" json:"active"`
+}
+
+// WebhookDelivery logs one attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	ID           uint            `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time       `gorm:"index" json:"created_at"`
+	WebhookID    uint            `gorm:"index;not null" json:"webhook_id"`
+	EventID      string          `gorm:"size:64;index;not null" json:"event_id"`
+	Event        string          `gorm:"size:64;not null" json:"event"`
+	Payload      json.RawMessage `gorm:"type:jsonb" json:"payload"`
+	Attempt      int             `json:"attempt"`
+	StatusCode   int             `json:"status_code,omitempty"`
+	ResponseBody string          `gorm:"size:1024" json:"response_body,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	DurationMs   int64           `json:"duration_ms"`
+	Success      bool            `json:"success"`
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"filesharing/jobs"
+	"filesharing/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ListJobs lists queued jobs, newest first, filtered by ?status= and ?type=
+func ListJobs(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, limitErr := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if limitErr != nil || limit <= 0 || limit > 500 {
+			limit = 50
+		}
+
+		query := db.Model(&models.Job{})
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		if jobType := c.Query("type"); jobType != "" {
+			query = query.Where("type = ?", jobType)
+		}
+		if before := c.Query("before_id"); before != "" {
+			query = query.Where("id < ?", before)
+		}
+
+		var list []models.Job
+		if err := query.Order("id DESC").Limit(limit).Find(&list).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"jobs": list})
+	}
+}
+
+type jobCount struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// JobStats counts jobs by type and status, plus the periodic schedules
+func JobStats(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var counts []jobCount
+		if err := db.Model(&models.Job{}).Select("type, status, count(*) AS count").
+			Group("type, status").Order("type, status").Scan(&counts).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job stats"})
+			return
+		}
+
+		var schedules []models.JobSchedule
+		if err := db.Order("name").Find(&schedules).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"counts": counts, "schedules": schedules})
+	}
+}
+
+func GetJob(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var job models.Job
+		if err := db.First(&job, c.Param("job_id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"job": job})
+	}
+}
+
+// RetryJob requeues a dead (or finished) job with its attempts reset
+func RetryJob(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobID, err := strconv.ParseUint(c.Param("job_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID format"})
+			return
+		}
+
+		job, err := jobs.RetryJob(db, uint(jobID))
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"job": job})
+	}
+}
@@ -0,0 +1,443 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/middleware"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxArchiveFiles caps the number of files in one ZIP download
+const maxArchiveFiles = 10000
+
+// archiveEntry is a file and the path it gets inside the archive
+type archiveEntry struct {
+	file models.File
+	path string
+}
+
+// archiveNames hands out unique paths inside an archive. Paths are compared
+// case-insensitively so archives extract cleanly on Windows and macOS.
+type archiveNames map[string]bool
+
+// add reserves p, appending " (n)" before the extension when it is taken
+func (names archiveNames) add(p string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	candidate := p
+	for n := 1; names[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	names[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// archiveSafeName keeps a user supplied file name from escaping its directory
+func archiveSafeName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_", "\x00", "").Replace(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// relativeFolderPath returns child's path below root, e.g. "sub/dir" or ""
+func relativeFolderPath(root, child string) string {
+	if root == "/" {
+		return strings.TrimPrefix(child, "/")
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(child, root), "/")
+}
+
+// folderArchive collects the directories and files below folder, rooted at
+// the folder's own name.
+func folderArchive(db *gorm.DB, folder models.Folder) ([]string, []archiveEntry, error) {
+	var folders []models.Folder
+	if err := db.Where("user_id = ? AND (path = ? OR path LIKE ?)", folder.UserID, folder.Path, utils.EscapeLike(folder.Path)+"/%").
+		Order("path").Find(&folders).Error; err != nil {
+		return nil, nil, err
+	}
+
+	dirs := make([]string, 0, len(folders))
+	paths := make(map[uint]string, len(folders))
+	ids := make([]uint, 0, len(folders))
+	for _, f := range folders {
+		dir := path.Join(folder.Name, relativeFolderPath(folder.Path, f.Path))
+		dirs = append(dirs, dir)
+		paths[f.ID] = dir
+		ids = append(ids, f.ID)
+	}
+
+	var files []models.File
+	// Files the malware scan flagged are left out
+	if err := db.Where("folder_id IN ? AND (expires_at IS NULL OR expires_at > ?) AND processing_steps->>'scan' IS DISTINCT FROM ?", ids, time.Now(), models.StepInfected).Order("folder_id, original_name").Limit(maxArchiveFiles + 1).Find(&files).Error; err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]archiveEntry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, archiveEntry{file: file, path: path.Join(paths[*file.FolderID], archiveSafeName(file.OriginalName))})
+	}
+	return dirs, entries, nil
+}
+
+// streamArchive writes the entries as a ZIP built on the fly from storage.
+// archive/zip switches to ZIP64 records by itself once an entry or the
+// archive passes 4 GiB or 65535 entries. When strip reports true for an
+// image, its metadata is removed first; images too large to sanitize are left
+// out rather than shared with their metadata. The compressed output is
+// throttled and accounted by t.
+func streamArchive(c *gin.Context, db *gorm.DB, name string, dirs []string, entries []archiveEntry, strip func(models.File) bool, t *transfer) {
+	if len(entries) > maxArchiveFiles {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Archives are limited to %d files", maxArchiveFiles)})
+		return
+	}
+
+	// Initialize S3 client
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize S3 client"})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	zw := zip.NewWriter(t.meter.Writer(c.Writer))
+	defer t.record(db)
+	names := archiveNames{}
+
+	for _, dir := range dirs {
+		if _, err := zw.Create(names.add(dir) + "/"); err != nil {
+			log.Printf("Error writing archive %s: %v", name, err)
+			return
+		}
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		file := entry.file
+
+		var body io.Reader
+		sanitize := strip != nil && strip(file)
+		if sanitize && file.Size > maxSanitizeBytes {
+			log.Printf("Leaving file %d out of archive %s: too large to sanitize", file.ID, name)
+			continue
+		}
+
+		obj, err := s3Client.GetObject(ctx, utils.FileObjectKey(&file))
+		if err != nil {
+			log.Printf("Leaving file %d out of archive %s: %v", file.ID, name, err)
+			continue
+		}
+		body = obj.Body
+
+		if sanitize {
+			data, err := io.ReadAll(io.LimitReader(obj.Body, maxSanitizeBytes))
+			if err == nil {
+				data, err = utils.StripImageMetadata(data, file.MimeType)
+			}
+			if err != nil {
+				obj.Body.Close()
+				log.Printf("Leaving file %d out of archive %s: %v", file.ID, name, err)
+				continue
+			}
+			body = bytes.NewReader(data)
+		}
+
+		header := &zip.FileHeader{
+			Name:     names.add(entry.path),
+			Method:   archiveMethod(file.MimeType),
+			Modified: file.UpdatedAt,
+		}
+		w, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.Copy(w, body)
+		}
+		obj.Body.Close()
+		if err != nil {
+			// The response has started, so all we can do is stop
+			log.Printf("Error writing file %d to archive %s: %v", file.ID, name, err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("Error finishing archive %s: %v", name, err)
+	}
+}
+
+// archiveMethod stores formats that are already compressed and deflates the rest
+func archiveMethod(mimeType string) uint16 {
+	switch {
+	case strings.HasPrefix(mimeType, "image/") && mimeType != "image/svg+xml" && mimeType != "image/bmp",
+		strings.HasPrefix(mimeType, "video/"), strings.HasPrefix(mimeType, "audio/"):
+		return zip.Store
+	}
+	switch mimeType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/x-bzip2", "application/x-xz", "application/pdf":
+		return zip.Store
+	}
+	// Office Open XML documents are ZIP packages already
+	if strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument.") {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// parseIDList parses a comma separated list of IDs such as "1,2,3"
+func parseIDList(raw string) ([]uint, error) {
+	var ids []uint
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return uniqueIDs(ids), nil
+}
+
+// DownloadArchive streams the files given by ?ids=1,2,3 and/or the folder
+// given by ?folder_id= as one ZIP archive.
+func DownloadArchive(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		ids, err := parseIDList(c.Query("ids"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+		folderID := c.Query("folder_id")
+		middleware.AddAuditDetail(c, "file_ids", ids)
+		if folderID != "" {
+			middleware.SetAuditTarget(c, "folder", folderID, userID.(uint))
+		}
+		if len(ids) == 0 && folderID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ids or folder_id is required"})
+			return
+		}
+		if len(ids) > maxArchiveFiles {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Archives are limited to %d files", maxArchiveFiles)})
+			return
+		}
+
+		name := "files"
+		var dirs []string
+		var entries []archiveEntry
+
+		if folderID != "" {
+			var folder models.Folder
+			if err := db.Where("id = ? AND user_id = ?", folderID, userID).First(&folder).Error; err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+				return
+			}
+			dirs, entries, err = folderArchive(db, folder)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+				return
+			}
+			name = folder.Name
+		}
+
+		if len(ids) > 0 {
+			var files []models.File
+			if err := db.Preload("Folder").Where("id IN ? AND user_id = ?", ids, userID).Find(&files).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+				return
+			}
+			if len(files) != len(ids) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "One or more files not found"})
+				return
+			}
+			// Individually selected files keep the folder path they live in
+			for _, file := range files {
+				dir := ""
+				if file.Folder != nil {
+					dir = strings.TrimPrefix(file.Folder.Path, "/")
+				}
+				entries = append(entries, archiveEntry{file: file, path: path.Join(dir, archiveSafeName(file.OriginalName))})
+			}
+		}
+
+		streamArchive(c, db, name, dirs, entries, nil, ownerTransfer(c.Request.Context(), userID.(uint)))
+	}
+}
+
+// sharedFolderURL is the public link for a shared folder
+func sharedFolderURL(token string) string {
+	return utils.PublicBaseURL() + "/api/folders/shared/" + token
+}
+
+// ShareFolder creates (or returns the existing) share link for a folder
+func ShareFolder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var folder models.Folder
+		if err := db.Where("id = ? AND user_id = ?", c.Param("folder_id"), userID).First(&folder).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		if folder.ShareToken == nil {
+			token, err := generateShareToken()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate share token"})
+				return
+			}
+			if err := db.Model(&folder).Update("share_token", token).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save share token"})
+				return
+			}
+			folder.ShareToken = &token
+			jobs.EmitEvent(db, folder.UserID, models.EventFolderShared, folderEvent(&folder))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"folder":    folder,
+			"share_url": sharedFolderURL(*folder.ShareToken),
+		})
+	}
+}
+
+// UnshareFolder revokes a folder's share link
+func UnshareFolder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var folder models.Folder
+		if err := db.Where("id = ? AND user_id = ?", c.Param("folder_id"), userID).First(&folder).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		if folder.ShareToken != nil {
+			if err := db.Model(&folder).Update("share_token", nil).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+				return
+			}
+			jobs.EmitEvent(db, folder.UserID, models.EventFolderUnshared, folderEvent(&folder))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+	}
+}
+
+// GetSharedFolder lists the contents of a shared folder
+func GetSharedFolder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		visit := newShareVisit(c, models.ShareView)
+		defer visit.record(db)
+
+		var folder models.Folder
+		if err := db.Where("share_token = ?", c.Param("token")).First(&folder).Error; err != nil {
+			visit.deny("not_found")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		visit.folder(folder)
+		middleware.SetAuditTarget(c, "folder", folder.ID, folder.UserID)
+
+		_, entries, err := folderArchive(db, folder)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+			return
+		}
+
+		files := make([]gin.H, 0, len(entries))
+		for _, entry := range entries {
+			files = append(files, gin.H{
+				"path":       entry.path,
+				"size":       entry.file.Size,
+				"mime_type":  entry.file.MimeType,
+				"updated_at": entry.file.UpdatedAt,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"folder":       gin.H{"name": folder.Name},
+			"files":        files,
+			"download_url": sharedFolderURL(*folder.ShareToken) + "/download",
+		})
+	}
+}
+
+// DownloadSharedFolder streams a shared folder as a ZIP archive, applying the
+// same metadata stripping as individually shared images.
+func DownloadSharedFolder(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		visit := newShareVisit(c, models.ShareDownload)
+		defer visit.record(db)
+
+		var folder models.Folder
+		if err := db.Where("share_token = ?", c.Param("token")).First(&folder).Error; err != nil {
+			visit.deny("not_found")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+		visit.folder(folder)
+		middleware.SetAuditTarget(c, "folder", folder.ID, folder.UserID)
+
+		dirs, entries, err := folderArchive(db, folder)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+			return
+		}
+
+		// Every file belongs to the folder's owner, so read their default once
+		stripDefault := true
+		var owner models.User
+		if err := db.Select("strip_shared_metadata").First(&owner, folder.UserID).Error; err == nil {
+			stripDefault = owner.StripSharedMetadata
+		}
+
+		t := sharedTransfer(c.Request.Context(), folder.UserID, "folder:"+*folder.ShareToken)
+		streamArchive(c, db, folder.Name, dirs, entries, func(file models.File) bool {
+			if !utils.CanStripMetadata(file.MimeType) {
+				return false
+			}
+			if file.StripMetadata != nil {
+				return *file.StripMetadata
+			}
+			return stripDefault
+		}, t)
+	}
+}
@@ -0,0 +1,167 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"filesharing/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// auditExportBatch is how many events an export reads per query
+const auditExportBatch = 1000
+
+// auditQuery applies the audit filters in the query string: action,
+// target_type, target_id, actor_id, owner_id, result and a from/to time
+// range (RFC 3339 or YYYY-MM-DD). Without admin, only events the caller
+// performed or that touched their data are visible.
+func auditQuery(c *gin.Context, db *gorm.DB, admin bool) (*gorm.DB, error) {
+	query := db.Model(&models.AuditEvent{})
+	if !admin {
+		userID, _ := c.Get("userID")
+		query = query.Where("(owner_id = ? OR actor_id = ?)", userID, userID)
+	}
+
+	for _, column := range []string{"action", "target_type", "target_id", "result"} {
+		if v := c.Query(column); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	for _, column := range []string{"actor_id", "owner_id"} {
+		if v := c.Query(column); v != "" {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s", column)
+			}
+			query = query.Where(column+" = ?", id)
+		}
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := parseAuditTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from")
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := parseAuditTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to")
+		}
+		query = query.Where("created_at < ?", to)
+	}
+	return query, nil
+}
+
+func parseAuditTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// listAuditEvents returns a page of events, newest first; older pages
+// follow with ?before_id=
+func listAuditEvents(db *gorm.DB, admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("userID"); !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		query, err := auditQuery(c, db, admin)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit, limitErr := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if limitErr != nil || limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		if before := c.Query("before_id"); before != "" {
+			query = query.Where("id < ?", before)
+		}
+
+		var events []models.AuditEvent
+		if err := query.Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+			return
+		}
+
+		response := gin.H{"events": events}
+		if len(events) == limit {
+			response["next_before_id"] = events[len(events)-1].ID
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// exportAuditEvents streams every matching event as JSON Lines, newest first
+func exportAuditEvents(db *gorm.DB, admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("userID"); !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		query, err := auditQuery(c, db, admin)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = query.Session(&gorm.Session{})
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		c.Status(http.StatusOK)
+
+		enc := json.NewEncoder(c.Writer)
+		var beforeID uint
+		for {
+			batch := query
+			if beforeID != 0 {
+				batch = batch.Where("id < ?", beforeID)
+			}
+			var events []models.AuditEvent
+			if err := batch.Order("id DESC").Limit(auditExportBatch).Find(&events).Error; err != nil {
+				// The response has started, so all we can do is stop
+				return
+			}
+			for i := range events {
+				if err := enc.Encode(&events[i]); err != nil {
+					return
+				}
+			}
+			c.Writer.Flush()
+			if len(events) < auditExportBatch || c.Request.Context().Err() != nil {
+				return
+			}
+			beforeID = events[len(events)-1].ID
+		}
+	}
+}
+
+// ListAuditEvents lists the caller's own actions and events on their data
+func ListAuditEvents(db *gorm.DB) gin.HandlerFunc {
+	return listAuditEvents(db, false)
+}
+
+// ExportAuditEvents exports the caller's audit events as JSON Lines
+func ExportAuditEvents(db *gorm.DB) gin.HandlerFunc {
+	return exportAuditEvents(db, false)
+}
+
+// AdminListAuditEvents lists every audit event
+func AdminListAuditEvents(db *gorm.DB) gin.HandlerFunc {
+	return listAuditEvents(db, true)
+}
+
+// AdminExportAuditEvents exports every matching audit event as JSON Lines
+func AdminExportAuditEvents(db *gorm.DB) gin.HandlerFunc {
+	return exportAuditEvents(db, true)
+}
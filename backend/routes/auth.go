@@ -3,6 +3,7 @@ package routes
 import (
 	"net/http"
 
+	"filesharing/middleware"
 	"filesharing/models"
 	"filesharing/utils"
 
@@ -55,6 +56,7 @@ func Register(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
+		middleware.SetAuditActor(c, user.ID)
 
 		c.JSON(http.StatusCreated, gin.H{"message": "User created successfully"})
 	}
@@ -68,11 +70,14 @@ func Login(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		middleware.AddAuditDetail(c, "email", req.Email)
 		var user models.User
 		if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
+		// Failed attempts are recorded against the account too
+		middleware.SetAuditActor(c, user.ID)
 
 		// Verify password
 		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
@@ -0,0 +1,351 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/middleware"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Bulk operation limits. Batches above BULK_SYNC_LIMIT files run as a
+// background job; each chunk of a batch is applied in its own transaction.
+const (
+	maxBulkFiles  = 10000
+	bulkChunkSize = 100
+)
+
+// Actions accepted by the bulk endpoint
+const (
+	bulkDelete  = "delete"
+	bulkRestore = "restore"
+	bulkMove    = "move"
+	bulkTag     = "tag"
+	bulkUntag   = "untag"
+	bulkShare   = "share"
+)
+
+// Queue job types for bulk operations and archive extraction
+const (
+	jobTypeBulk    = "bulk"
+	jobTypeExtract = "extract"
+)
+
+// bulkJobPayload points a queued job at the BulkJob that tracks its progress
+type bulkJobPayload struct {
+	BulkJobID uint `json:"bulk_job_id"`
+}
+
+func init() {
+	// Every bulk action is idempotent, so a retry after a crash is safe
+	jobs.Register(jobTypeBulk, jobs.HandlerOptions{MaxAttempts: 3, Timeout: time.Hour}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		var p bulkJobPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return jobs.Permanent(err)
+		}
+		return runBulkJob(db, cache, p.BulkJobID)
+	})
+}
+
+type BulkRequest struct {
+	Action   string   `json:"action" binding:"required"`
+	FileIDs  []uint   `json:"file_ids" binding:"required"`
+	FolderID *uint    `json:"folder_id"`
+	Tags     []string `json:"tags"`
+}
+
+// validate checks the action's parameters and the user's access to the target folder
+func (req *BulkRequest) validate(db *gorm.DB, userID uint) (int, string) {
+	req.FileIDs = uniqueIDs(req.FileIDs)
+	if len(req.FileIDs) == 0 {
+		return http.StatusBadRequest, "file_ids must not be empty"
+	}
+	if len(req.FileIDs) > maxBulkFiles {
+		return http.StatusBadRequest, fmt.Sprintf("At most %d files per request", maxBulkFiles)
+	}
+
+	switch req.Action {
+	case bulkDelete, bulkRestore, bulkShare:
+	case bulkMove:
+		if req.FolderID != nil {
+			var folder models.Folder
+			if err := db.Where("id = ? AND user_id = ?", *req.FolderID, userID).First(&folder).Error; err != nil {
+				return http.StatusNotFound, "Folder not found"
+			}
+		}
+	case bulkTag, bulkUntag:
+		if len(req.Tags) == 0 {
+			return http.StatusBadRequest, "tags must not be empty"
+		}
+		for i, name := range req.Tags {
+			normalized, ok := normalizeTagName(name)
+			if !ok {
+				return http.StatusBadRequest, "Invalid tag name"
+			}
+			req.Tags[i] = normalized
+		}
+	default:
+		return http.StatusBadRequest, "Unknown action " + req.Action
+	}
+	return 0, ""
+}
+
+// BulkFiles applies one action to many files. Small batches run inline and
+// return a result per file; large ones are queued and return a job to poll.
+func BulkFiles(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req BulkRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		middleware.AddAuditDetail(c, "action", req.Action)
+		middleware.AddAuditDetail(c, "file_ids", req.FileIDs)
+		if status, msg := req.validate(db, userID.(uint)); status != 0 {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if int64(len(req.FileIDs)) > utils.EnvInt64("BULK_SYNC_LIMIT", 100) {
+			params, _ := json.Marshal(req)
+			job := models.BulkJob{
+				UserID: userID.(uint),
+				Action: req.Action,
+				Status: models.BulkQueued,
+				Total:  len(req.FileIDs),
+				Params: params,
+			}
+			if err := db.Create(&job).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bulk job"})
+				return
+			}
+
+			if _, err := jobs.Enqueue(db, jobTypeBulk, bulkJobPayload{BulkJobID: job.ID}); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue bulk job"})
+				return
+			}
+
+			c.JSON(http.StatusAccepted, gin.H{"job": job})
+			return
+		}
+
+		results := executeBulk(c.Request.Context(), db, cache, userID.(uint), req, nil)
+		succeeded := 0
+		for _, result := range results {
+			if result.OK {
+				succeeded++
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"action":    req.Action,
+			"succeeded": succeeded,
+			"failed":    len(results) - succeeded,
+			"results":   results,
+		})
+	}
+}
+
+// GetBulkJob reports the progress and, once finished, the results of a bulk job
+func GetBulkJob(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var job models.BulkJob
+		if err := db.Where("id = ? AND user_id = ?", c.Param("job_id"), userID).First(&job).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"job": job})
+	}
+}
+
+func runBulkJob(db *gorm.DB, cache utils.Cache, jobID uint) error {
+	var job models.BulkJob
+	if err := db.First(&job, jobID).Error; err != nil {
+		return jobs.Permanent(err)
+	}
+	var req BulkRequest
+	if err := json.Unmarshal(job.Params, &req); err != nil {
+		db.Model(&job).Updates(map[string]interface{}{"status": models.BulkFailed, "error": "Invalid job parameters"})
+		return jobs.Permanent(err)
+	}
+	db.Model(&job).Update("status", models.BulkRunning)
+
+	results := executeBulk(context.Background(), db, cache, job.UserID, req, func(done []models.BulkResult) {
+		succeeded := 0
+		for _, result := range done {
+			if result.OK {
+				succeeded++
+			}
+		}
+		db.Model(&job).Updates(map[string]interface{}{
+			"processed": len(done),
+			"succeeded": succeeded,
+			"failed":    len(done) - succeeded,
+		})
+	})
+
+	now := time.Now()
+	job.Status = models.BulkCompleted
+	job.Results = results
+	job.FinishedAt = &now
+	return db.Model(&job).Select("Status", "Results", "FinishedAt").Updates(&job).Error
+}
+
+// executeBulk applies req chunk by chunk and returns a result per requested
+// file in request order. A chunk whose transaction fails is reported as failed
+// item by item; earlier chunks stay applied. progress, if set, is called with
+// the results so far after every chunk.
+func executeBulk(ctx context.Context, db *gorm.DB, cache utils.Cache, userID uint, req BulkRequest, progress func([]models.BulkResult)) []models.BulkResult {
+	results := make([]models.BulkResult, 0, len(req.FileIDs))
+
+	for start := 0; start < len(req.FileIDs); start += bulkChunkSize {
+		end := start + bulkChunkSize
+		if end > len(req.FileIDs) {
+			end = len(req.FileIDs)
+		}
+		results = append(results, executeBulkChunk(db, userID, req, req.FileIDs[start:end])...)
+		if progress != nil {
+			progress(results)
+		}
+	}
+
+	utils.InvalidateFileListings(ctx, cache, userID)
+	return results
+}
+
+func executeBulkChunk(db *gorm.DB, userID uint, req BulkRequest, ids []uint) []models.BulkResult {
+	results := make([]models.BulkResult, len(ids))
+	for i, id := range ids {
+		results[i] = models.BulkResult{FileID: id}
+	}
+
+	// Restore works on trashed files, every other action on live ones
+	query := db.Where("id IN ? AND user_id = ?", ids, userID)
+	if req.Action == bulkRestore {
+		query = db.Unscoped().Where("id IN ? AND user_id = ? AND deleted_at IS NOT NULL", ids, userID)
+	}
+	var files []models.File
+	if err := query.Find(&files).Error; err != nil {
+		return failResults(results, "Failed to fetch files")
+	}
+
+	found := make(map[uint]*models.File, len(files))
+	var targets []uint
+	for i := range files {
+		found[files[i].ID] = &files[i]
+		targets = append(targets, files[i].ID)
+	}
+	for i := range results {
+		if found[results[i].FileID] == nil {
+			results[i].Error = "File not found"
+		}
+	}
+	if len(targets) == 0 {
+		return results
+	}
+
+	shareURLs := map[uint]string{}
+	newlyShared := map[uint]bool{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		switch req.Action {
+		case bulkDelete:
+			return tx.Where("id IN ?", targets).Delete(&models.File{}).Error
+		case bulkRestore:
+			return tx.Unscoped().Model(&models.File{}).Where("id IN ?", targets).Update("deleted_at", nil).Error
+		case bulkMove:
+			return tx.Model(&models.File{}).Where("id IN ?", targets).Update("folder_id", req.FolderID).Error
+		case bulkTag:
+			tags, err := ensureTags(tx, userID, req.Tags)
+			if err != nil {
+				return err
+			}
+			return tagFiles(tx, targets, tags)
+		case bulkUntag:
+			return tx.Exec(`DELETE FROM file_tags WHERE file_id IN ? AND tag_id IN
+				(SELECT id FROM tags WHERE user_id = ? AND lower(name) IN ? AND deleted_at IS NULL)`,
+				targets, userID, lowerNames(req.Tags)).Error
+		case bulkShare:
+			for _, id := range targets {
+				file := found[id]
+				if file.ShareToken == "" {
+					token, err := generateShareToken()
+					if err != nil {
+						return err
+					}
+					if err := tx.Model(file).Update("share_token", token).Error; err != nil {
+						return err
+					}
+					file.ShareToken = token
+					newlyShared[id] = true
+				}
+				shareURLs[id] = sharedFileURL(file.ShareToken)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error applying bulk %s for user %d: %v", req.Action, userID, err)
+	} else {
+		emitBulkEvents(db, userID, req.Action, files, newlyShared)
+	}
+
+	for i := range results {
+		if found[results[i].FileID] == nil {
+			continue
+		}
+		if err != nil {
+			results[i].Error = "Failed to " + req.Action + " file"
+			continue
+		}
+		results[i].OK = true
+		results[i].ShareURL = shareURLs[results[i].FileID]
+	}
+	return results
+}
+
+// emitBulkEvents sends the webhook events of an applied bulk chunk
+func emitBulkEvents(db *gorm.DB, userID uint, action string, files []models.File, newlyShared map[uint]bool) {
+	for i := range files {
+		switch {
+		case action == bulkDelete:
+			jobs.EmitEvent(db, userID, models.EventFileDeleted, fileEvent(&files[i]))
+		case action == bulkRestore:
+			jobs.EmitEvent(db, userID, models.EventFileRestored, fileEvent(&files[i]))
+		case action == bulkShare && newlyShared[files[i].ID]:
+			jobs.EmitEvent(db, userID, models.EventFileShared, fileEvent(&files[i]))
+		case action == bulkMove:
+			jobs.EmitEvent(db, userID, models.EventFileUpdated, fileUpdateEvent(files[i].ID, "folder_id"))
+		case action == bulkTag, action == bulkUntag:
+			jobs.EmitEvent(db, userID, models.EventFileUpdated, fileUpdateEvent(files[i].ID, "tags"))
+		}
+	}
+}
+
+func failResults(results []models.BulkResult, msg string) []models.BulkResult {
+	for i := range results {
+		results[i].Error = msg
+	}
+	return results
+}
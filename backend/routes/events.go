@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventHeartbeat keeps idle streams open through proxies that time them out
+const eventHeartbeat = 25 * time.Second
+
+// CreateEventTicket issues a single-use ticket for opening the event stream
+// with EventSource, which cannot send the Authorization header
+func CreateEventTicket(cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		ticket, ttl, err := utils.NewStreamTicket(c.Request.Context(), cache, userID.(uint), c.GetString("email"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create ticket"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"ticket": ticket, "expires_in": int(ttl.Seconds())})
+	}
+}
+
+// StreamEvents pushes changes to the user's files as Server-Sent Events.
+// A reconnecting client sends Last-Event-ID and gets the events it missed;
+// when those are no longer retained it gets a reset event and should reload.
+func StreamEvents(bus utils.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		lastID := c.GetHeader("Last-Event-ID")
+		if lastID == "" {
+			lastID = c.Query("last_event_id")
+		}
+
+		ctx := c.Request.Context()
+		sub := bus.Subscribe(ctx, userID.(uint), lastID)
+
+		startEventStream(c)
+		if sub.Missed {
+			writeEvent(c, "", "reset", []byte("{}"))
+		}
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				writeEvent(c, event.ID, event.Type, event.Data)
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+			case <-ctx.Done():
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// startEventStream sends the Server-Sent Events headers and the reconnect delay
+func startEventStream(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+}
+
+// writeEvent writes one event; data must be JSON, which has no raw newlines
+func writeEvent(c *gin.Context, id, eventType string, data []byte) {
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", eventType, data)
+}
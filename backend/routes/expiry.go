@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateExpiryRequest struct {
+	ExpiresAt string `json:"expires_at"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// uploadExpiry reads the expires_at or expires_in form field of an upload,
+// falling back to the user's default expiry when neither is given.
+func uploadExpiry(db *gorm.DB, c *gin.Context, userID uint) (*time.Time, error) {
+	now := time.Now()
+	expiresAt, set, err := utils.ResolveExpiry(c.PostForm("expires_at"), c.PostForm("expires_in"), now)
+	if err != nil || set {
+		return expiresAt, err
+	}
+
+	var user models.User
+	if err := db.Select("default_expiry_seconds").First(&user, userID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.DefaultExpirySeconds <= 0 {
+		return nil, nil
+	}
+	t := now.Add(time.Duration(user.DefaultExpirySeconds) * time.Second)
+	return &t, nil
+}
+
+// fileExpired reports whether a file is past its expiry but not yet cleaned up
+func fileExpired(file models.File) bool {
+	return file.ExpiresAt != nil && !file.ExpiresAt.After(time.Now())
+}
+
+// UpdateFileExpiry sets or clears ("never") a file's expiry
+func UpdateFileExpiry(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req UpdateExpiryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		expiresAt, set, err := utils.ResolveExpiry(req.ExpiresAt, req.ExpiresIn, time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !set {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at or expires_in is required"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		// A new expiry deserves a new warning
+		if err := db.Model(&file).Updates(map[string]interface{}{
+			"expires_at":       expiresAt,
+			"expiry_warned_at": nil,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update expiry"})
+			return
+		}
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+		jobs.EmitEvent(db, file.UserID, models.EventFileUpdated, fileUpdateEvent(file.ID, "expires_at"))
+
+		c.JSON(http.StatusOK, gin.H{"expires_at": expiresAt})
+	}
+}
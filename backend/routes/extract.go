@@ -0,0 +1,489 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// bulkExtract is the job action recorded for archive extraction
+const bulkExtract = "extract"
+
+// extractJobPayload identifies the tracking job, the archive and the target folder
+type extractJobPayload struct {
+	BulkJobID uint `json:"bulk_job_id"`
+	FileID    uint `json:"file_id"`
+	FolderID  uint `json:"folder_id"`
+}
+
+func init() {
+	// A half-finished extraction cannot be retried without duplicating files
+	jobs.Register(jobTypeExtract, jobs.HandlerOptions{MaxAttempts: 1, Timeout: 2 * time.Hour}, func(ctx context.Context, db *gorm.DB, cache utils.Cache, payload json.RawMessage) error {
+		var p extractJobPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return jobs.Permanent(err)
+		}
+
+		var job models.BulkJob
+		if err := db.First(&job, p.BulkJobID).Error; err != nil {
+			return jobs.Permanent(err)
+		}
+		var file models.File
+		var folder models.Folder
+		err := db.Where("id = ? AND user_id = ?", p.FileID, job.UserID).First(&file).Error
+		if err == nil {
+			err = db.Where("id = ? AND user_id = ?", p.FolderID, job.UserID).First(&folder).Error
+		}
+		if err != nil {
+			db.Model(&job).Updates(map[string]interface{}{"status": models.BulkFailed, "error": "Archive or folder no longer exists"})
+			return jobs.Permanent(err)
+		}
+
+		runExtractJob(ctx, db, cache, job.ID, file, folder, utils.ArchiveKind(file.MimeType, file.OriginalName))
+		return nil
+	})
+}
+
+// errArchiveTooLarge aborts an extraction that exceeds the configured limits
+var errArchiveTooLarge = errors.New("archive exceeds extraction limits")
+
+type ExtractRequest struct {
+	FolderID *uint  `json:"folder_id"`
+	Name     string `json:"name"`
+}
+
+// extractLimits bounds what one extraction may produce, to defuse zip bombs
+type extractLimits struct {
+	archiveBytes int64
+	entries      int64
+	totalBytes   int64
+}
+
+func loadExtractLimits() extractLimits {
+	return extractLimits{
+		archiveBytes: utils.EnvInt64("EXTRACT_MAX_ARCHIVE_BYTES", 2<<30),
+		entries:      utils.EnvInt64("EXTRACT_MAX_ENTRIES", 10000),
+		totalBytes:   utils.EnvInt64("EXTRACT_MAX_BYTES", 10<<30),
+	}
+}
+
+// ExtractArchive unpacks a stored zip, tar or tar.gz into a new folder. The
+// folder is created right away; its files appear as the returned job runs.
+func ExtractArchive(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req ExtractRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		kind := utils.ArchiveKind(file.MimeType, file.OriginalName)
+		if kind == "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "File is not a zip, tar or tar.gz archive"})
+			return
+		}
+		if limits := loadExtractLimits(); file.Size > limits.archiveBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Archive is too large to extract"})
+			return
+		}
+
+		// Extract next to the archive unless told otherwise
+		parentID := file.FolderID
+		if req.FolderID != nil {
+			parentID = req.FolderID
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = archiveBaseName(file.OriginalName)
+		}
+		if !validFolderName(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder name"})
+			return
+		}
+
+		folder, err := createUniqueFolder(db, userID.(uint), parentID, name)
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Parent folder not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create folder"})
+			return
+		}
+		utils.InvalidateFolderListings(c.Request.Context(), cache, userID.(uint))
+
+		params, _ := json.Marshal(gin.H{"file_id": file.ID, "folder_id": folder.ID})
+		job := models.BulkJob{
+			UserID: userID.(uint),
+			Action: bulkExtract,
+			Status: models.BulkQueued,
+			Params: params,
+		}
+		if err := db.Create(&job).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create extract job"})
+			return
+		}
+		payload := extractJobPayload{BulkJobID: job.ID, FileID: file.ID, FolderID: folder.ID}
+		if _, err := jobs.Enqueue(db, jobTypeExtract, payload); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue extract job"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"job": job, "folder": folder})
+	}
+}
+
+// archiveBaseName strips archive extensions: "site.tar.gz" becomes "site"
+func archiveBaseName(filename string) string {
+	name := filepath.Base(filename)
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// createUniqueFolder creates name under parentID, adding " (n)" if it is taken
+func createUniqueFolder(db *gorm.DB, userID uint, parentID *uint, name string) (*models.Folder, error) {
+	candidate := name
+	for n := 1; n <= 100; n++ {
+		folder, err := createFolder(db, userID, parentID, candidate)
+		if err == nil || err == gorm.ErrRecordNotFound {
+			return folder, err
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, n)
+	}
+	return nil, errors.New("no free folder name")
+}
+
+func runExtractJob(ctx context.Context, db *gorm.DB, cache utils.Cache, jobID uint, archive models.File, folder models.Folder, kind string) {
+	var job models.BulkJob
+	if err := db.First(&job, jobID).Error; err != nil {
+		log.Printf("Error loading extract job %d: %v", jobID, err)
+		return
+	}
+	db.Model(&job).Update("status", models.BulkRunning)
+
+	results, err := extractArchive(ctx, db, &job, archive, folder, kind)
+
+	now := time.Now()
+	job.Status = models.BulkCompleted
+	job.Results = results
+	job.FinishedAt = &now
+	if err != nil {
+		log.Printf("Error extracting file %d: %v", archive.ID, err)
+		job.Status = models.BulkFailed
+		job.Error = err.Error()
+	}
+	if err := db.Model(&job).Select("Status", "Results", "Error", "FinishedAt", "Processed", "Succeeded", "Failed").Updates(&job).Error; err != nil {
+		log.Printf("Error saving extract job %d: %v", jobID, err)
+	}
+	utils.InvalidateFileListings(context.Background(), cache, archive.UserID)
+	utils.InvalidateFolderListings(context.Background(), cache, archive.UserID)
+}
+
+// extractArchive downloads the archive to a temporary file, checks its
+// declared entry count and size against the limits, then unpacks it. Sizes
+// are enforced again while reading since archives can lie about them.
+func extractArchive(ctx context.Context, db *gorm.DB, job *models.BulkJob, archive models.File, folder models.Folder, kind string) ([]models.BulkResult, error) {
+	limits := loadExtractLimits()
+
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := downloadToTemp(ctx, s3Client, archive, limits.archiveBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+
+	// First pass: count entries and add up declared sizes
+	var entries, declared int64
+	err = utils.WalkArchive(src, kind, func(entry utils.ArchiveEntry, _ io.Reader) error {
+		entries++
+		if entry.Regular {
+			declared += entry.Size
+		}
+		if entries > limits.entries || declared > limits.totalBytes {
+			return errArchiveTooLarge
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	job.Total = int(entries)
+	db.Model(job).Update("total", job.Total)
+
+	// Second pass: recreate directories as folders and upload every regular file
+	dirs := map[string]*uint{"": &folder.ID}
+	remaining := limits.totalBytes
+	var results []models.BulkResult
+
+	err = utils.WalkArchive(src, kind, func(entry utils.ArchiveEntry, body io.Reader) error {
+		// Stop once the job times out or the worker shuts down
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		defer func() {
+			job.Processed++
+			if job.Processed%25 == 0 {
+				db.Model(job).Updates(map[string]interface{}{
+					"processed": job.Processed,
+					"succeeded": job.Succeeded,
+					"failed":    job.Failed,
+				})
+			}
+		}()
+
+		rel, err := utils.SafeArchivePath(entry.Name)
+		if err != nil {
+			job.Failed++
+			results = append(results, models.BulkResult{Path: entry.Name, Error: err.Error()})
+			return nil
+		}
+		// Skip resource forks added by the macOS archiver
+		if rel == "__MACOSX" || strings.HasPrefix(rel, "__MACOSX/") {
+			return nil
+		}
+
+		if entry.Dir {
+			_, err := ensureArchiveDir(db, folder.UserID, dirs, rel)
+			if err != nil {
+				job.Failed++
+				results = append(results, models.BulkResult{Path: rel, Error: "Failed to create folder"})
+			}
+			return nil
+		}
+		if !entry.Regular {
+			job.Failed++
+			results = append(results, models.BulkResult{Path: rel, Error: "Links and special files are not extracted"})
+			return nil
+		}
+
+		parentID, err := ensureArchiveDir(db, folder.UserID, dirs, path.Dir(rel))
+		if err != nil {
+			job.Failed++
+			results = append(results, models.BulkResult{Path: rel, Error: "Failed to create folder"})
+			return nil
+		}
+
+		file, n, err := storeArchiveEntry(ctx, db, s3Client, folder.UserID, parentID, path.Base(rel), body, remaining)
+		if err == errArchiveTooLarge {
+			return err
+		}
+		remaining -= n
+		if err != nil {
+			log.Printf("Error extracting %s from file %d: %v", rel, archive.ID, err)
+			job.Failed++
+			results = append(results, models.BulkResult{Path: rel, Error: "Failed to store file"})
+			return nil
+		}
+		job.Succeeded++
+		results = append(results, models.BulkResult{FileID: file.ID, Path: rel, OK: true})
+		return nil
+	})
+	return results, err
+}
+
+// downloadToTemp copies a stored object into a temporary file so archive
+// readers can seek in it
+func downloadToTemp(ctx context.Context, s3Client *utils.S3Client, file models.File, limit int64) (*os.File, error) {
+	obj, err := s3Client.GetObject(ctx, utils.FileObjectKey(&file))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	tmp, err := os.CreateTemp("", "extract-*")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(obj.Body, limit+1))
+	if err == nil && n > limit {
+		err = errArchiveTooLarge
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// ensureArchiveDir returns the folder for a directory of the archive,
+// creating it and any missing parents below the extraction folder.
+func ensureArchiveDir(db *gorm.DB, userID uint, dirs map[string]*uint, dir string) (*uint, error) {
+	if dir == "." {
+		dir = ""
+	}
+	if id, ok := dirs[dir]; ok {
+		return id, nil
+	}
+
+	parentID, err := ensureArchiveDir(db, userID, dirs, path.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(dir)
+	if !validFolderName(name) {
+		return nil, utils.ErrUnsafeArchivePath
+	}
+
+	// The folder may exist already when a directory entry follows its files
+	var folder models.Folder
+	err = db.Where("user_id = ? AND parent_id = ? AND name = ?", userID, *parentID, name).First(&folder).Error
+	if err == gorm.ErrRecordNotFound {
+		created, cerr := createFolder(db, userID, parentID, name)
+		if cerr != nil {
+			return nil, cerr
+		}
+		folder, err = *created, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dirs[dir] = &folder.ID
+	return &folder.ID, nil
+}
+
+// extractedFilename returns a storage name that stays unique when many files
+// are created within the same second
+func extractedFilename(ext string) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(b) + ext
+}
+
+// storeArchiveEntry uploads one archive member as a new file. It reads at
+// most limit bytes and returns errArchiveTooLarge beyond that.
+func storeArchiveEntry(ctx context.Context, db *gorm.DB, s3Client *utils.S3Client, userID uint, folderID *uint, name string, body io.Reader, limit int64) (*models.File, int64, error) {
+	tmp, err := os.CreateTemp("", "entry-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, n, err
+	}
+	if n > limit {
+		return nil, n, errArchiveTooLarge
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, n, err
+	}
+	sums, _, err := utils.ComputeChecksums(tmp)
+	if err != nil {
+		return nil, n, err
+	}
+
+	// Archives carry no content types, so go by extension and then by content
+	ext := filepath.Ext(name)
+	contentType := strings.Split(mime.TypeByExtension(ext), ";")[0]
+	if contentType == "" {
+		head := make([]byte, 512)
+		m, _ := tmp.ReadAt(head, 0)
+		contentType = strings.Split(http.DetectContentType(head[:m]), ";")[0]
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, n, err
+	}
+	imageMeta, err := extractUploadMetadata(tmp, contentType)
+	if err != nil {
+		return nil, n, err
+	}
+
+	createdAt := time.Now()
+	filename := extractedFilename(ext)
+	key := utils.ObjectKey(createdAt, filename)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, n, err
+	}
+	if err := s3Client.PutObject(ctx, key, tmp, contentType, &sums); err != nil {
+		return nil, n, err
+	}
+
+	shareToken, err := generateShareToken()
+	if err != nil {
+		return nil, n, err
+	}
+	file := models.File{
+		UserID:         userID,
+		FolderID:       folderID,
+		Filename:       filename,
+		ObjectKey:      key,
+		OriginalName:   name,
+		Size:           n,
+		MimeType:       contentType,
+		ShareToken:     shareToken,
+		ChecksumSHA256: sums.SHA256,
+		ChecksumMD5:    sums.MD5,
+		ChecksumCRC32C: sums.CRC32C,
+		CreatedAt:      createdAt,
+
+		ProcessingStatus: models.ProcessingUploaded,
+	}
+	if err := db.Create(&file).Error; err != nil {
+		go s3Client.DeleteObject(context.Background(), key)
+		return nil, n, err
+	}
+
+	if imageMeta != nil {
+		saveImageMetadata(db, file.ID, imageMeta)
+	}
+	if err := jobs.EnqueueProcessing(db, &file); err != nil {
+		log.Printf("Error queueing processing for file %d: %v", file.ID, err)
+	}
+	jobs.EmitEvent(db, userID, models.EventFileUploaded, fileEvent(&file))
+	return &file, n, nil
+}
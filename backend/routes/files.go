@@ -1,16 +1,20 @@
 package routes
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"time"
 
+	"filesharing/jobs"
+	"filesharing/middleware"
 	"filesharing/models"
 	"filesharing/utils"
 
@@ -28,7 +32,12 @@ func generateShareToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-func UploadFile(db *gorm.DB) gin.HandlerFunc {
+// sharedFileURL is the public link for a shared file
+func sharedFileURL(token string) string {
+	return utils.PublicBaseURL() + "/api/files/shared/" + token
+}
+
+func UploadFile(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("userID")
 		if !exists {
@@ -42,9 +51,25 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Generate unique filename
+		// Resolve the optional destination folder
+		folderID, err := formFolderID(db, c, userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		// Resolve the expiry, falling back to the user's default
+		expiresAt, err := uploadExpiry(db, c, userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Generate unique filename and the key it is stored under
+		createdAt := time.Now()
 		ext := filepath.Ext(file.Filename)
-		filename := time.Now().Format("20060102150405") + ext
+		filename := createdAt.Format("20060102150405") + ext
+		key := utils.ObjectKey(createdAt, filename)
 
 		// Open the uploaded file
 		src, err := file.Open()
@@ -54,6 +79,28 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 		}
 		defer src.Close()
 
+		// Compute checksums before upload so truncated or corrupted payloads are caught
+		sums, n, err := utils.ComputeChecksums(src)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		if n != file.Size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded file is truncated"})
+			return
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+
+		// Extract image metadata before the reader is handed to the S3 upload
+		imageMeta, err := extractUploadMetadata(src, file.Header.Get("Content-Type"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+
 		// Create channels for goroutine communication
 		uploadChan := make(chan string, 1)
 		dbChan := make(chan error, 1)
@@ -68,7 +115,7 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 
 		// Goroutine for S3 upload
 		go func() {
-			url, err := s3Client.UploadFile(c.Request.Context(), src, filename, file.Header.Get("Content-Type"))
+			url, err := s3Client.UploadFile(c.Request.Context(), src, key, file.Header.Get("Content-Type"), &sums)
 			if err != nil {
 				errChan <- fmt.Errorf("S3 upload failed: %v", err)
 				return
@@ -85,13 +132,21 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 			}
 
 			fileRecord := models.File{
-				UserID:       userID.(uint),
-				Filename:     filename,
-				OriginalName: file.Filename,
-				Size:         file.Size,
-				MimeType:     file.Header.Get("Content-Type"),
-				ShareToken:   shareToken,
-				CreatedAt:    time.Now(),
+				UserID:         userID.(uint),
+				FolderID:       folderID,
+				Filename:       filename,
+				ObjectKey:      key,
+				OriginalName:   file.Filename,
+				Size:           file.Size,
+				MimeType:       file.Header.Get("Content-Type"),
+				ExpiresAt:      expiresAt,
+				ShareToken:     shareToken,
+				ChecksumSHA256: sums.SHA256,
+				ChecksumMD5:    sums.MD5,
+				ChecksumCRC32C: sums.CRC32C,
+				CreatedAt:      createdAt,
+
+				ProcessingStatus: models.ProcessingUploaded,
 			}
 
 			if err := db.Create(&fileRecord).Error; err != nil {
@@ -111,8 +166,9 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 			return
 		case url := <-uploadChan:
 			if err := <-dbChan; err != nil {
-				// If database operation fails, try to delete the uploaded file
-				go s3Client.DeleteFile(c.Request.Context(), filename)
+				// If database operation fails, try to delete the uploaded file.
+				// The request context is cancelled once we respond, so use a detached one.
+				go s3Client.DeleteObject(context.Background(), key)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
@@ -137,11 +193,28 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 				"mime_type":     fileRecord.MimeType,
 				"share_token":   fileRecord.ShareToken,
 				"share_url":     url,
+				"sha256":        fileRecord.ChecksumSHA256,
+				"expires_at":    fileRecord.ExpiresAt,
 			}
 
+			middleware.SetAuditTarget(c, "file", fileRecord.ID, fileRecord.UserID)
+
 			// Invalidate the cache for this user's files
-			cacheKey := "user:files:" + string(userID.(uint))
-			utils.DeleteCache(cacheKey)
+			utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+
+			// Count the upload towards the user's transfer usage
+			recordUsage(db, fileRecord.UserID, models.TransferUsage{UploadedBytes: fileRecord.Size})
+			jobs.EmitEvent(db, fileRecord.UserID, models.EventFileUploaded, fileEvent(&fileRecord))
+
+			if imageMeta != nil {
+				saveImageMetadata(db, fileRecord.ID, imageMeta)
+			}
+
+			// Scan, thumbnail and index in the background so the upload returns quickly
+			if err := jobs.EnqueueProcessing(db, &fileRecord); err != nil {
+				log.Printf("Error queueing processing for file %d: %v", fileRecord.ID, err)
+			}
+			formattedFile["processing_status"] = fileRecord.ProcessingStatus
 
 			c.JSON(http.StatusOK, gin.H{
 				"message": "File uploaded successfully",
@@ -151,7 +224,15 @@ func UploadFile(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-func ListFiles(db *gorm.DB) gin.HandlerFunc {
+// fileListing is one page of a file listing as it is cached. Presigned URLs
+// are generated per request so a cached page never hands out expired links.
+type fileListing struct {
+	Files      []models.File `json:"files"`
+	Total      int64         `json:"total"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+func ListFiles(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("userID")
 		if !exists {
@@ -159,12 +240,17 @@ func ListFiles(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Get files from database first
-		var files []models.File
-		if err := db.Where("user_id = ?", userID).Find(&files).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		// Get the requested page of files, from the cache when possible
+		page := pageRequest(c)
+		listing, err := utils.ReadThrough(c.Request.Context(), cache, utils.FileListCacheKey(c.Request.Context(), cache, userID.(uint), c.Query("q"), page), utils.ListCacheTTL(), func() (fileListing, error) {
+			files, total, nextCursor, err := findFiles(db, userID, c.Query("q"), page)
+			return fileListing{Files: files, Total: total, NextCursor: nextCursor}, err
+		})
+		if err != nil {
+			respondQueryError(c, err, "Failed to fetch files")
 			return
 		}
+		files, total, nextCursor := listing.Files, listing.Total, listing.NextCursor
 
 		// Initialize S3 client
 		s3Client, err := utils.NewS3Client()
@@ -174,36 +260,28 @@ func ListFiles(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Check if each file exists in S3 and generate presigned URLs
+		// Generate presigned URLs; missing objects are handled by the reconcile job
 		var validFiles []models.File
+		presignClient := s3.NewPresignClient(s3Client.Client)
 		for _, file := range files {
-			key := fmt.Sprintf("uploads/%s/%s", file.CreatedAt.Format("2006/01/02"), file.Filename)
-			if err := s3Client.HeadObject(c.Request.Context(), key); err == nil {
-				// Generate presigned URL for the file
-				presignClient := s3.NewPresignClient(s3Client.Client)
-				presignResult, err := presignClient.PresignGetObject(c.Request.Context(), &s3.GetObjectInput{
-					Bucket: aws.String(s3Client.Bucket),
-					Key:    aws.String(key),
-				}, func(opts *s3.PresignOptions) {
-					opts.Expires = time.Hour * 24 * 7 // 7 days
-				})
-				if err == nil {
-					file.ShareToken = presignResult.URL // Use ShareToken instead of ShareURL
-					validFiles = append(validFiles, file)
-				}
-			} else {
-				// File doesn't exist in S3, delete it from database
-				db.Delete(&file)
+			key := utils.FileObjectKey(&file)
+			presignResult, err := presignClient.PresignGetObject(c.Request.Context(), &s3.GetObjectInput{
+				Bucket: aws.String(s3Client.Bucket),
+				Key:    aws.String(key),
+			}, func(opts *s3.PresignOptions) {
+				opts.Expires = time.Hour * 24 * 7 // 7 days
+			})
+			if err == nil {
+				file.ShareToken = presignResult.URL // Use ShareToken instead of ShareURL
+				validFiles = append(validFiles, file)
 			}
 		}
 
-		// Cache the valid files
-		cacheKey := fmt.Sprintf("user:files:%d", userID)
-		if validFilesJSON, err := json.Marshal(validFiles); err == nil {
-			utils.SetCache(cacheKey, string(validFilesJSON), time.Hour)
-		}
-
-		c.JSON(http.StatusOK, gin.H{"files": validFiles})
+		c.JSON(http.StatusOK, gin.H{
+			"files":       validFiles,
+			"total":       total,
+			"next_cursor": nextCursor,
+		})
 	}
 }
 
@@ -251,21 +329,11 @@ func ShareFile(db *gorm.DB) gin.HandlerFunc {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save share token"})
 				return
 			}
+			jobs.EmitEvent(db, file.UserID, models.EventFileShared, fileEvent(&file))
 		}
 
-		// Initialize S3 client
-		s3Client, err := utils.NewS3Client()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize S3 client"})
-			return
-		}
-
-		// Generate presigned URL
-		url, err := s3Client.UploadFile(c.Request.Context(), nil, file.Filename, file.MimeType)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
-			return
-		}
+		// The link goes through the backend so downloads are metered and counted
+		url := sharedFileURL(file.ShareToken)
 
 		// Format file for response
 		formattedFile := gin.H{
@@ -302,17 +370,21 @@ func SearchFiles(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		var files []models.File
-		if err := db.Where("user_id = ? AND original_name LIKE ?", userID, "%"+query+"%").Find(&files).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files"})
+		files, total, nextCursor, err := findFiles(db, userID, query, pageRequest(c))
+		if err != nil {
+			respondQueryError(c, err, "Failed to search files")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"files": files})
+		c.JSON(http.StatusOK, gin.H{
+			"files":       files,
+			"total":       total,
+			"next_cursor": nextCursor,
+		})
 	}
 }
 
-func DeleteFile(db *gorm.DB) gin.HandlerFunc {
+func ReplaceFile(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("userID")
 		if !exists {
@@ -320,9 +392,7 @@ func DeleteFile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		fileID := c.Param("file_id")
-		// Convert file ID to uint
-		fileIDUint, err := strconv.ParseUint(fileID, 10, 64)
+		fileIDUint, err := strconv.ParseUint(c.Param("file_id"), 10, 64)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
 			return
@@ -334,6 +404,47 @@ func DeleteFile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		upload, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+			return
+		}
+
+		// A replacement may also move the expiry; without one it is kept
+		expiresAt, expirySet, err := utils.ResolveExpiry(c.PostForm("expires_at"), c.PostForm("expires_in"), time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		src, err := upload.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+			return
+		}
+		defer src.Close()
+
+		sums, n, err := utils.ComputeChecksums(src)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		if n != upload.Size {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded file is truncated"})
+			return
+		}
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+
+		contentType := upload.Header.Get("Content-Type")
+		imageMeta, err := extractUploadMetadata(src, contentType)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+
 		// Initialize S3 client
 		s3Client, err := utils.NewS3Client()
 		if err != nil {
@@ -341,49 +452,159 @@ func DeleteFile(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Delete from S3
-		if err := s3Client.DeleteFile(c.Request.Context(), file.Filename); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file from S3"})
+		// Store the new content under a new key and switch the row over to it
+		oldKey := utils.FileObjectKey(&file)
+		now := time.Now()
+		filename := now.Format("20060102150405") + filepath.Ext(upload.Filename)
+		key := utils.ObjectKey(now, filename)
+		if err := s3Client.PutObject(c.Request.Context(), key, src, contentType, &sums); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
 			return
 		}
 
-		// Delete from database
+		updates := map[string]interface{}{
+			"filename":        filename,
+			"object_key":      key,
+			"original_name":   upload.Filename,
+			"size":            upload.Size,
+			"mime_type":       contentType,
+			"checksum_sha256": sums.SHA256,
+			"checksum_md5":    sums.MD5,
+			"checksum_crc32c": sums.CRC32C,
+		}
+		if expirySet {
+			updates["expires_at"] = expiresAt
+			updates["expiry_warned_at"] = nil
+		}
+		if err := db.Model(&file).Updates(updates).Error; err != nil {
+			go s3Client.DeleteObject(context.Background(), key)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update file"})
+			return
+		}
+
+		if err := db.First(&file, file.ID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch file record"})
+			return
+		}
+
+		// Drop the previous content and everything derived from it
+		if err := s3Client.DeleteObject(c.Request.Context(), oldKey); err != nil {
+			log.Printf("Error deleting replaced object %s: %v", oldKey, err)
+		}
+		if err := jobs.DeleteThumbnails(c.Request.Context(), db, s3Client, file.ID); err != nil {
+			log.Printf("Error deleting thumbnails for file %d: %v", file.ID, err)
+		}
+		if err := jobs.DeleteIndex(db, file.ID); err != nil {
+			log.Printf("Error deleting search index for file %d: %v", file.ID, err)
+		}
+		db.Unscoped().Where("file_id = ?", file.ID).Delete(&models.FileMetadata{})
+		if imageMeta != nil {
+			saveImageMetadata(db, file.ID, imageMeta)
+		}
+
+		if err := jobs.EnqueueProcessing(db, &file); err != nil {
+			log.Printf("Error queueing processing for file %d: %v", file.ID, err)
+		}
+
+		// Invalidate the cache for this user's files
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+
+		// Count the upload towards the user's transfer usage
+		recordUsage(db, file.UserID, models.TransferUsage{UploadedBytes: file.Size})
+		jobs.EmitEvent(db, file.UserID, models.EventFileReplaced, fileEvent(&file))
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "File replaced successfully",
+			"file":    file,
+		})
+	}
+}
+
+func DeleteFile(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		fileID := c.Param("file_id")
+		// Convert file ID to uint
+		fileIDUint, err := strconv.ParseUint(fileID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", uint(fileIDUint), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		// Move to the trash; the object and derived data are purged by the trash job
 		if err := db.Delete(&file).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file from database"})
 			return
 		}
 
 		// Invalidate cache
-		cacheKey := fmt.Sprintf("files:%d", userID)
-		utils.DeleteCache(cacheKey)
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+		jobs.EmitEvent(db, file.UserID, models.EventFileDeleted, fileEvent(&file))
 
 		c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
 	}
 }
 
+// ListTrash lists the user's deleted files that can still be restored
+func ListTrash(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var files []models.File
+		if err := db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+			Order("deleted_at DESC").Limit(utils.MaxPageSize).Find(&files).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"files": files})
+	}
+}
+
 func GetSharedFile(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		visit := newShareVisit(c, models.ShareView)
+		defer visit.record(db)
+
 		token := c.Param("token")
 		var file models.File
 		if err := db.Where("share_token = ?", token).First(&file).Error; err != nil {
+			visit.deny("not_found")
 			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 			return
 		}
-
-		// Initialize S3 client
-		s3Client, err := utils.NewS3Client()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize S3 client"})
+		visit.file(file)
+		middleware.SetAuditTarget(c, "file", file.ID, file.UserID)
+		if fileExpired(file) {
+			visit.deny("expired")
+			c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
 			return
 		}
-
-		// Generate presigned URL
-		url, err := s3Client.UploadFile(c.Request.Context(), nil, file.Filename, file.MimeType)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate download URL"})
+		if file.Quarantined() {
+			visit.deny("quarantined")
+			c.JSON(http.StatusForbidden, gin.H{"error": "File failed a malware scan"})
 			return
 		}
 
+		// Downloads go through the backend so they are metered, counted and
+		// sanitized per the share settings
+		url := sharedFileURL(token) + "/download"
+
 		// Format file for response
 		formattedFile := gin.H{
 			"ID":            file.ID,
@@ -404,3 +625,107 @@ func GetSharedFile(db *gorm.DB) gin.HandlerFunc {
 		})
 	}
 }
+
+func DownloadFile(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		fileIDUint, err := strconv.ParseUint(c.Param("file_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", uint(fileIDUint), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		serveFile(c, db, file, ownerTransfer(c.Request.Context(), file.UserID))
+	}
+}
+
+func DownloadSharedFile(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		visit := newShareVisit(c, models.ShareDownload)
+		defer visit.record(db)
+
+		var file models.File
+		if err := db.Where("share_token = ?", c.Param("token")).First(&file).Error; err != nil {
+			visit.deny("not_found")
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		visit.file(file)
+		middleware.SetAuditTarget(c, "file", file.ID, file.UserID)
+		if fileExpired(file) {
+			visit.deny("expired")
+			c.JSON(http.StatusGone, gin.H{"error": "File has expired"})
+			return
+		}
+		if file.Quarantined() {
+			visit.deny("quarantined")
+			c.JSON(http.StatusForbidden, gin.H{"error": "File failed a malware scan"})
+			return
+		}
+
+		t := sharedTransfer(c.Request.Context(), file.UserID, "file:"+file.ShareToken)
+		if shouldStripMetadata(db, file) {
+			serveSanitizedImage(c, db, file, t)
+			return
+		}
+
+		serveFile(c, db, file, t)
+	}
+}
+
+// serveFile streams the stored object of file with its Digest and ETag
+// headers, throttled and accounted by t
+func serveFile(c *gin.Context, db *gorm.DB, file models.File, t *transfer) {
+	sums := utils.Checksums{SHA256: file.ChecksumSHA256}
+	etag := ""
+	if file.ChecksumSHA256 != "" {
+		etag = `"` + file.ChecksumSHA256 + `"`
+		if c.GetHeader("If-None-Match") == etag {
+			c.Header("ETag", etag)
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Initialize S3 client
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize S3 client"})
+		return
+	}
+
+	obj, err := s3Client.GetObject(c.Request.Context(), utils.FileObjectKey(&file))
+	if err != nil {
+		log.Printf("Error fetching file %d from S3: %v", file.ID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		return
+	}
+	defer obj.Body.Close()
+
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	headers := map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": file.OriginalName}),
+	}
+	if etag != "" {
+		headers["ETag"] = etag
+		headers["Digest"] = "sha-256=" + sums.SHA256Base64()
+	}
+
+	c.DataFromReader(http.StatusOK, aws.ToInt64(obj.ContentLength), contentType, t.meter.Reader(obj.Body), headers)
+	t.record(db)
+}
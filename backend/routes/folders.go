@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"filesharing/middleware"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CreateFolderRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentID *uint  `json:"parent_id"`
+}
+
+// validFolderName rejects names that would break materialized paths
+func validFolderName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func CreateFolder(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req CreateFolderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if !validFolderName(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder name"})
+			return
+		}
+
+		folder, err := createFolder(db, userID.(uint), req.ParentID, name)
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Parent folder not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Folder already exists"})
+			return
+		}
+
+		middleware.SetAuditTarget(c, "folder", folder.ID, folder.UserID)
+		utils.InvalidateFolderListings(c.Request.Context(), cache, userID.(uint))
+		c.JSON(http.StatusCreated, gin.H{"folder": folder})
+	}
+}
+
+// createFolder creates a folder under parentID (nil for the root)
+func createFolder(db *gorm.DB, userID uint, parentID *uint, name string) (*models.Folder, error) {
+	parentPath := "/"
+	if parentID != nil {
+		var parent models.Folder
+		if err := db.Where("id = ? AND user_id = ?", *parentID, userID).First(&parent).Error; err != nil {
+			return nil, err
+		}
+		parentPath = parent.Path
+	}
+
+	folder := models.Folder{
+		UserID:   userID,
+		ParentID: parentID,
+		Name:     name,
+		Path:     path.Join(parentPath, name),
+	}
+	if err := db.Create(&folder).Error; err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func ListFolders(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		folders, err := utils.ReadThrough(c.Request.Context(), cache, utils.FolderListCacheKey(c.Request.Context(), cache, userID.(uint)), utils.ListCacheTTL(), func() ([]models.Folder, error) {
+			var folders []models.Folder
+			err := db.Where("user_id = ?", userID).Order("path").Find(&folders).Error
+			return folders, err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch folders"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"folders": folders})
+	}
+}
+
+// formFolderID reads the optional folder_id form field and checks the folder
+// belongs to the user. A missing field means the root folder.
+func formFolderID(db *gorm.DB, c *gin.Context, userID uint) (*uint, error) {
+	raw := c.PostForm("folder_id")
+	if raw == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var folder models.Folder
+	if err := db.Where("id = ? AND user_id = ?", uint(id), userID).First(&folder).Error; err != nil {
+		return nil, err
+	}
+	return &folder.ID, nil
+}
@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// imageMetadataPrefix is how much of an upload is read to find EXIF and dimensions
+	imageMetadataPrefix = 256 << 10
+	// maxSanitizeBytes caps images rewritten in memory to strip their metadata
+	maxSanitizeBytes = 50 << 20
+)
+
+type ShareSettingsRequest struct {
+	// Null falls back to the owner's default
+	StripMetadata *bool `json:"strip_metadata"`
+}
+
+func saveImageMetadata(db *gorm.DB, fileID uint, meta *utils.ImageMetadata) {
+	record := models.FileMetadata{
+		FileID:      fileID,
+		Width:       meta.Width,
+		Height:      meta.Height,
+		CameraMake:  meta.CameraMake,
+		CameraModel: meta.CameraModel,
+		TakenAt:     meta.TakenAt,
+		Orientation: meta.Orientation,
+		HasGPS:      meta.Latitude != nil,
+		Latitude:    meta.Latitude,
+		Longitude:   meta.Longitude,
+	}
+	if err := db.Create(&record).Error; err != nil {
+		log.Printf("Error saving metadata for file %d: %v", fileID, err)
+	}
+}
+
+// extractUploadMetadata reads image metadata from the start of an upload and
+// rewinds it so the reader can still be streamed to S3.
+func extractUploadMetadata(src multipart.File, contentType string) (*utils.ImageMetadata, error) {
+	if !utils.Thumbnailable(contentType) {
+		return nil, nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(src, imageMetadataPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	meta, err := utils.ExtractImageMetadata(head)
+	if err != nil {
+		// Not a decodable image; there is simply nothing to record
+		return nil, nil
+	}
+	return meta, nil
+}
+
+func GetFileMetadata(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		fileIDUint, err := strconv.ParseUint(c.Param("file_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+
+		var meta models.FileMetadata
+		err = db.Joins("JOIN files ON files.id = file_metadata.file_id").
+			Where("files.id = ? AND files.user_id = ? AND files.deleted_at IS NULL", uint(fileIDUint), userID).
+			First(&meta).Error
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Metadata not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"metadata": meta})
+	}
+}
+
+func SearchImageMetadata(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		query := db.Model(&models.File{}).
+			Joins("JOIN file_metadata ON file_metadata.file_id = files.id AND file_metadata.deleted_at IS NULL").
+			Where("files.user_id = ?", userID)
+
+		if camera := c.Query("camera"); camera != "" {
+			like := "%" + camera + "%"
+			query = query.Where("file_metadata.camera_make ILIKE ? OR file_metadata.camera_model ILIKE ?", like, like)
+		}
+		if after := c.Query("taken_after"); after != "" {
+			t, err := time.Parse(time.RFC3339, after)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid taken_after, expected RFC 3339"})
+				return
+			}
+			query = query.Where("file_metadata.taken_at >= ?", t)
+		}
+		if before := c.Query("taken_before"); before != "" {
+			t, err := time.Parse(time.RFC3339, before)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid taken_before, expected RFC 3339"})
+				return
+			}
+			query = query.Where("file_metadata.taken_at < ?", t)
+		}
+		if hasGPS := c.Query("has_gps"); hasGPS != "" {
+			b, err := strconv.ParseBool(hasGPS)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid has_gps"})
+				return
+			}
+			query = query.Where("file_metadata.has_gps = ?", b)
+		}
+
+		var files []models.File
+		if err := query.Find(&files).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"files": files})
+	}
+}
+
+func UpdateShareSettings(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		fileIDUint, err := strconv.ParseUint(c.Param("file_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+
+		var req ShareSettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", uint(fileIDUint), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		if err := db.Model(&file).Update("strip_metadata", req.StripMetadata).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update share settings"})
+			return
+		}
+		utils.InvalidateFileListings(c.Request.Context(), cache, file.UserID)
+		jobs.EmitEvent(db, file.UserID, models.EventFileUpdated, fileUpdateEvent(file.ID, "strip_metadata"))
+
+		c.JSON(http.StatusOK, gin.H{"strip_metadata": req.StripMetadata})
+	}
+}
+
+// shouldStripMetadata decides whether a shared file is served sanitized,
+// letting the per-share setting override the owner's default.
+func shouldStripMetadata(db *gorm.DB, file models.File) bool {
+	if !utils.CanStripMetadata(file.MimeType) {
+		return false
+	}
+	if file.StripMetadata != nil {
+		return *file.StripMetadata
+	}
+
+	var owner models.User
+	if err := db.Select("strip_shared_metadata").First(&owner, file.UserID).Error; err != nil {
+		// Fail closed: without the owner's preference, strip
+		return true
+	}
+	return owner.StripSharedMetadata
+}
+
+// serveSanitizedImage streams a copy of an image with its metadata removed,
+// throttled and accounted by t
+func serveSanitizedImage(c *gin.Context, db *gorm.DB, file models.File, t *transfer) {
+	if file.Size > maxSanitizeBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large to sanitize"})
+		return
+	}
+
+	// Initialize S3 client
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize S3 client"})
+		return
+	}
+
+	obj, err := s3Client.GetObject(c.Request.Context(), utils.FileObjectKey(&file))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File content not found"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(obj.Body, maxSanitizeBytes))
+	obj.Body.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	clean, err := utils.StripImageMetadata(data, file.MimeType)
+	if err != nil {
+		log.Printf("Error stripping metadata from file %d: %v", file.ID, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to sanitize image"})
+		return
+	}
+
+	sum := sha256.Sum256(clean)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Header("ETag", etag)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, int64(len(clean)), file.MimeType, t.meter.Reader(bytes.NewReader(clean)), map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": file.OriginalName}),
+		"ETag":                etag,
+		"Digest":              "sha-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+	})
+	t.record(db)
+}
@@ -0,0 +1,351 @@
+package routes
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/middleware"
+	"filesharing/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxShareRecipients caps the users one share can be sent to at once
+const maxShareRecipients = 50
+
+type MarkNotificationsReadRequest struct {
+	IDs []uint `json:"ids"`
+}
+
+type UpdateNotificationRequest struct {
+	Read *bool `json:"read" binding:"required"`
+}
+
+type SendShareRequest struct {
+	To      []string `json:"to" binding:"required"`
+	Message string   `json:"message"`
+}
+
+// ListNotifications returns the user's in-app notifications, newest first
+func ListNotifications(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 || limit > 200 {
+			limit = 50
+		}
+		query := db.Where("user_id = ? AND in_app", userID)
+		if before := c.Query("before_id"); before != "" {
+			query = query.Where("id < ?", before)
+		}
+		if c.Query("unread") == "true" {
+			query = query.Where("read_at IS NULL")
+		}
+
+		var notifications []models.Notification
+		if err := query.Order("id DESC").Limit(limit).Find(&notifications).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+			return
+		}
+
+		var unread int64
+		if err := db.Model(&models.Notification{}).
+			Where("user_id = ? AND in_app AND read_at IS NULL", userID).Count(&unread).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count notifications"})
+			return
+		}
+
+		response := gin.H{"notifications": notifications, "unread_count": unread}
+		if len(notifications) == limit {
+			response["next_before_id"] = notifications[len(notifications)-1].ID
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// MarkNotificationsRead marks the given notifications read, or all of them
+// when no IDs are sent
+func MarkNotificationsRead(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req MarkNotificationsReadRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		query := db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+		if len(req.IDs) > 0 {
+			query = query.Where("id IN ?", req.IDs)
+			middleware.AddAuditDetail(c, "notification_ids", req.IDs)
+		}
+		result := query.Update("read_at", time.Now())
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"updated": result.RowsAffected})
+	}
+}
+
+// UpdateNotification marks one notification read or unread
+func UpdateNotification(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req UpdateNotificationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var notification models.Notification
+		if err := db.Where("id = ? AND user_id = ?", c.Param("notification_id"), userID).First(&notification).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification"})
+			return
+		}
+
+		if *req.Read && notification.ReadAt == nil {
+			now := time.Now()
+			notification.ReadAt = &now
+		} else if !*req.Read {
+			notification.ReadAt = nil
+		}
+		if err := db.Model(&notification).Select("ReadAt").Updates(&notification).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+			return
+		}
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// GetNotificationPreferences returns the channels used for each notification type
+func GetNotificationPreferences(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		prefs, err := jobs.NotificationPreferences(db, userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification preferences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"preferences": prefs})
+	}
+}
+
+// UpdateNotificationPreferences sets the channels of the types in the body,
+// a map from type to {"in_app", "email"}; other types are left as they are
+func UpdateNotificationPreferences(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req map[string]models.NotificationPreference
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No preferences provided"})
+			return
+		}
+
+		prefs := make([]models.NotificationPreference, 0, len(req))
+		for notificationType, pref := range req {
+			if !slices.Contains(models.NotificationTypes, notificationType) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type: " + notificationType})
+				return
+			}
+			pref.UserID, pref.Type = userID.(uint), notificationType
+			prefs = append(prefs, pref)
+		}
+
+		if err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+			DoUpdates: clause.AssignmentColumns([]string{"in_app", "email"}),
+		}).Create(&prefs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save notification preferences"})
+			return
+		}
+
+		updated, err := jobs.NotificationPreferences(db, userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification preferences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"preferences": updated})
+	}
+}
+
+// SendFileShare shares a file's link with other users by notifying them
+func SendFileShare(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req SendShareRequest
+		if !bindSendShare(c, &req) {
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch file"})
+			return
+		}
+		if file.Quarantined() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "File failed a malware scan"})
+			return
+		}
+
+		if file.ShareToken == "" {
+			token, err := generateShareToken()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate share token"})
+				return
+			}
+			if err := db.Model(&file).Update("share_token", token).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save share token"})
+				return
+			}
+			file.ShareToken = token
+			jobs.EmitEvent(db, file.UserID, models.EventFileShared, fileEvent(&file))
+		}
+
+		shareURL := sharedFileURL(file.ShareToken)
+		sendShare(db, c, userID.(uint), req, file.OriginalName, shareURL, gin.H{"file_id": file.ID})
+	}
+}
+
+// SendFolderShare shares a folder's link with other users by notifying them
+func SendFolderShare(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req SendShareRequest
+		if !bindSendShare(c, &req) {
+			return
+		}
+
+		var folder models.Folder
+		if err := db.Where("id = ? AND user_id = ?", c.Param("folder_id"), userID).First(&folder).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		if folder.ShareToken == nil {
+			token, err := generateShareToken()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate share token"})
+				return
+			}
+			if err := db.Model(&folder).Update("share_token", token).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save share token"})
+				return
+			}
+			folder.ShareToken = &token
+			jobs.EmitEvent(db, folder.UserID, models.EventFolderShared, folderEvent(&folder))
+		}
+
+		sendShare(db, c, userID.(uint), req, folder.Name, sharedFolderURL(*folder.ShareToken), gin.H{"folder_id": folder.ID})
+	}
+}
+
+func bindSendShare(c *gin.Context, req *SendShareRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if len(req.To) == 0 || len(req.To) > maxShareRecipients {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Between 1 and " + strconv.Itoa(maxShareRecipients) + " recipients are required"})
+		return false
+	}
+	if len(req.Message) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message is too long"})
+		return false
+	}
+	return true
+}
+
+// sendShare notifies each recipient, named by username or email, that the
+// sender shared the link with them. Unknown recipients are skipped silently
+// so the response does not reveal which addresses are registered.
+func sendShare(db *gorm.DB, c *gin.Context, senderID uint, req SendShareRequest, name, shareURL string, data gin.H) {
+	var sender models.User
+	if err := db.First(&sender, senderID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	var recipients []models.User
+	if err := db.Where("username IN ? OR email IN ?", req.To, req.To).Find(&recipients).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up recipients"})
+		return
+	}
+
+	data["from_user_id"] = sender.ID
+	data["from_username"] = sender.Username
+	for _, user := range recipients {
+		if user.ID == sender.ID {
+			continue
+		}
+		jobs.Notify(db, models.Notification{
+			UserID: user.ID,
+			Type:   models.NotifyShareReceived,
+			Title:  sender.Username + " shared " + name + " with you",
+			Body:   req.Message,
+			Link:   shareURL,
+			Data:   data,
+		})
+	}
+	middleware.AddAuditDetail(c, "recipients", len(req.To))
+
+	c.JSON(http.StatusOK, gin.H{"share_url": shareURL, "message": "Share sent"})
+}
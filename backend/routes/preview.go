@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Size caps for server-side previews
+const (
+	previewMaxBytes     = 1 << 20  // read from text, markdown and CSV objects
+	previewMaxPDFBytes  = 20 << 20 // PDFs must be read whole to find the xref table
+	previewDefaultChars = 4096
+	previewMaxChars     = 65536
+	previewDefaultRows  = 20
+	previewMaxRows      = 500
+)
+
+func PreviewFile(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		fileIDUint, err := strconv.ParseUint(c.Param("file_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", uint(fileIDUint), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		kind := utils.PreviewKind(file.MimeType, file.OriginalName)
+		if kind == "" {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Preview is not available for this file type"})
+			return
+		}
+
+		limit := int64(previewMaxBytes)
+		if kind == utils.PreviewPDF {
+			if file.Size > previewMaxPDFBytes {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large to preview"})
+				return
+			}
+			limit = previewMaxPDFBytes
+		}
+
+		data, err := readObjectPrefix(c, file, limit)
+		if err != nil {
+			log.Printf("Error reading file %d for preview: %v", file.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+			return
+		}
+		truncated := file.Size > int64(len(data))
+
+		switch kind {
+		case utils.PreviewPDF:
+			info, err := utils.ReadPDFInfo(data)
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to parse PDF"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"type": kind, "pages": info.Pages, "info": info.Info})
+
+		case utils.PreviewCSV:
+			text, encoding, err := utils.DecodeText(data, file.MimeType)
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to decode file"})
+				return
+			}
+			rows := boundedQueryInt(c, "rows", previewDefaultRows, previewMaxRows)
+			columns, records, more, err := utils.CSVPreview(strings.NewReader(text), rows)
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to parse CSV"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"type":      kind,
+				"encoding":  encoding,
+				"columns":   columns,
+				"rows":      records,
+				"truncated": truncated || more,
+			})
+
+		default:
+			text, encoding, err := utils.DecodeText(data, file.MimeType)
+			if err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to decode file"})
+				return
+			}
+			chars := boundedQueryInt(c, "chars", previewDefaultChars, previewMaxChars)
+			excerpt, cut := utils.TextExcerpt(text, chars)
+
+			if kind == utils.PreviewMarkdown {
+				html, err := utils.RenderMarkdown(excerpt)
+				if err != nil {
+					c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to render markdown"})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{
+					"type":      kind,
+					"encoding":  encoding,
+					"html":      html,
+					"truncated": truncated || cut,
+				})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"type":      kind,
+				"encoding":  encoding,
+				"content":   excerpt,
+				"truncated": truncated || cut,
+			})
+		}
+	}
+}
+
+// readObjectPrefix reads at most limit bytes from the start of a file's object
+func readObjectPrefix(c *gin.Context, file models.File, limit int64) ([]byte, error) {
+	s3Client, err := utils.NewS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Size == 0 {
+		return []byte{}, nil
+	}
+	obj, err := s3Client.GetObjectRange(c.Request.Context(), utils.FileObjectKey(&file), 0, min(limit, file.Size))
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	return io.ReadAll(io.LimitReader(obj.Body, limit))
+}
+
+// boundedQueryInt reads a positive integer query parameter clamped to max
+func boundedQueryInt(c *gin.Context, name string, def, max int) int {
+	n, err := strconv.Atoi(c.Query(name))
+	if err != nil || n <= 0 {
+		return def
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"filesharing/jobs"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// processingFinished reports whether a file has reached a final state
+func processingFinished(status string) bool {
+	return status == models.ProcessingReady || status == models.ProcessingFailed
+}
+
+// GetFileStatus reports where a file's scanning, thumbnails and indexing stand
+func GetFileStatus(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, jobs.FileProcessingStatus(&file))
+	}
+}
+
+// StreamFileStatus sends a file's processing state as Server-Sent Events:
+// the current state first, then every change, ending once the file is
+// ready or failed. A file that is already finished gets 204, which stops
+// EventSource from reconnecting.
+func StreamFileStatus(db *gorm.DB, bus utils.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		// Subscribe before reading the state so no change falls in between
+		ctx := c.Request.Context()
+		sub := bus.Subscribe(ctx, userID.(uint), "")
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		status := jobs.FileProcessingStatus(&file)
+		if processingFinished(status.Status) {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		data, _ := json.Marshal(status)
+		startEventStream(c)
+		writeEvent(c, "", jobs.EventFileProcessing, data)
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(eventHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				if event.Type != jobs.EventFileProcessing {
+					continue
+				}
+				var update jobs.ProcessingStatus
+				if err := json.Unmarshal(event.Data, &update); err != nil || update.FileID != file.ID {
+					continue
+				}
+				writeEvent(c, "", event.Type, event.Data)
+				c.Writer.Flush()
+				if processingFinished(update.Status) {
+					return
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+				c.Writer.Flush()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package routes
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Sentinels wrapped around ts_headline matches so the snippet can be HTML
+// escaped before the highlights are turned into <mark> tags.
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+const contentSearchLimit = 50
+
+// findFiles runs a structured query over the user's files with sorting and
+// cursor pagination. It returns the page, the total number of matches and the
+// cursor of the next page, if any.
+func findFiles(db *gorm.DB, userID interface{}, input string, page utils.PageRequest) ([]models.File, int64, string, error) {
+	query, err := utils.ParseFileQuery(input)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if err := page.Normalize(); err != nil {
+		return nil, 0, "", err
+	}
+
+	base := query.Apply(db.Model(&models.File{}).Where("files.user_id = ?", userID)).Session(&gorm.Session{})
+
+	var total int64
+	if err := base.Count(&total).Error; err != nil {
+		return nil, 0, "", err
+	}
+
+	paged, err := page.Apply(base)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	var files []models.File
+	if err := paged.Preload("Tags").Find(&files).Error; err != nil {
+		return nil, 0, "", err
+	}
+
+	files, next := page.Trim(files)
+	return files, total, next, nil
+}
+
+// pageRequest reads the sort, order, limit and cursor query parameters
+func pageRequest(c *gin.Context) utils.PageRequest {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return utils.PageRequest{
+		Sort:   c.Query("sort"),
+		Order:  c.Query("order"),
+		Limit:  limit,
+		Cursor: c.Query("cursor"),
+	}
+}
+
+// respondQueryError reports malformed queries as 400 and anything else as 500
+func respondQueryError(c *gin.Context, err error, msg string) {
+	var queryErr *utils.QueryError
+	if errors.As(err, &queryErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": queryErr.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+}
+
+type ContentSearchResult struct {
+	models.File
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+func SearchContent(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		query := c.Query("query")
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+			return
+		}
+
+		headlineOptions := "StartSel=" + highlightStart + ", StopSel=" + highlightStop +
+			", MaxFragments=3, MinWords=5, MaxWords=20, FragmentDelimiter=\" … \""
+
+		var results []ContentSearchResult
+		err := db.Raw(`SELECT files.*,
+				ts_rank_cd(fc.search_vector, q) AS rank,
+				ts_headline('english', fc.content, q, ?) AS snippet
+			FROM files
+			JOIN file_contents fc ON fc.file_id = files.id AND fc.deleted_at IS NULL,
+				websearch_to_tsquery('english', ?) q
+			WHERE files.user_id = ? AND files.deleted_at IS NULL AND fc.search_vector @@ q
+			ORDER BY rank DESC
+			LIMIT ?`, headlineOptions, query, userID, contentSearchLimit).Scan(&results).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files"})
+			return
+		}
+
+		for i := range results {
+			results[i].Snippet = highlightSnippet(results[i].Snippet)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"files": results})
+	}
+}
+
+// highlightSnippet escapes a ts_headline snippet and marks the matched terms
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightStop, "</mark>")
+}
+
+// Fuzzy search tuning
+const (
+	fuzzyDefaultMinScore = 0.3
+	// fuzzyFallbackScanLimit caps the names scored in Go when pg_trgm is unavailable
+	fuzzyFallbackScanLimit = 5000
+)
+
+type FuzzySearchResult struct {
+	models.File
+	Score float64 `json:"score"`
+}
+
+func FuzzySearchFiles(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		query := strings.TrimSpace(c.Query("query"))
+		if query == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+			return
+		}
+
+		minScore := fuzzyDefaultMinScore
+		if raw := c.Query("min_score"); raw != "" {
+			score, err := strconv.ParseFloat(raw, 64)
+			if err != nil || score <= 0 || score > 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "min_score must be between 0 and 1"})
+				return
+			}
+			minScore = score
+		}
+		limit := boundedQueryInt(c, "limit", utils.DefaultPageSize, utils.MaxPageSize)
+
+		var results []FuzzySearchResult
+		var err error
+		if utils.TrigramSearchEnabled() {
+			results, err = trigramSearch(db, userID, query, minScore, limit)
+		} else {
+			results, err = fallbackFuzzySearch(db, userID, query, minScore, limit)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search files"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"files": results})
+	}
+}
+
+// trigramSearch ranks files by the better of their name and their best
+// matching tag with pg_trgm, using the thresholds of the similarity
+// operators so the trigram indexes can be used.
+func trigramSearch(db *gorm.DB, userID interface{}, query string, minScore float64, limit int) ([]FuzzySearchResult, error) {
+	var results []FuzzySearchResult
+	threshold := strconv.FormatFloat(minScore, 'f', -1, 64)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`SELECT set_config('pg_trgm.similarity_threshold', ?, true),
+			set_config('pg_trgm.word_similarity_threshold', ?, true)`, threshold, threshold).Error; err != nil {
+			return err
+		}
+
+		return tx.Raw(`WITH search AS (SELECT lower(f_unaccent(?)) AS q),
+			tag_matches AS (
+				SELECT file_tags.file_id,
+					MAX(GREATEST(similarity(lower(f_unaccent(tags.name)), search.q),
+						word_similarity(search.q, lower(f_unaccent(tags.name))))) AS score
+				FROM tags
+				JOIN file_tags ON file_tags.tag_id = tags.id
+				CROSS JOIN search
+				WHERE tags.user_id = ? AND tags.deleted_at IS NULL
+					AND (lower(f_unaccent(tags.name)) % search.q OR search.q <% lower(f_unaccent(tags.name)))
+				GROUP BY file_tags.file_id
+			)
+			SELECT files.*,
+				GREATEST(similarity(lower(f_unaccent(files.original_name)), search.q),
+					word_similarity(search.q, lower(f_unaccent(files.original_name))),
+					COALESCE(tag_matches.score, 0)) AS score
+			FROM files
+			CROSS JOIN search
+			LEFT JOIN tag_matches ON tag_matches.file_id = files.id
+			WHERE files.user_id = ? AND files.deleted_at IS NULL
+				AND (lower(f_unaccent(files.original_name)) % search.q OR search.q <% lower(f_unaccent(files.original_name))
+					OR tag_matches.file_id IS NOT NULL)
+			ORDER BY score DESC, files.id DESC
+			LIMIT ?`, query, userID, userID, limit).Scan(&results).Error
+	})
+	return results, err
+}
+
+// fallbackFuzzySearch scores the names and tags of the user's most recent
+// files in Go
+func fallbackFuzzySearch(db *gorm.DB, userID interface{}, query string, minScore float64, limit int) ([]FuzzySearchResult, error) {
+	var files []models.File
+	if err := db.Preload("Tags").Where("user_id = ?", userID).Order("id DESC").Limit(fuzzyFallbackScanLimit).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	results := []FuzzySearchResult{}
+	for _, file := range files {
+		score := utils.Similarity(query, file.OriginalName)
+		for _, tag := range file.Tags {
+			score = max(score, utils.Similarity(query, tag.Name))
+		}
+		if score >= minScore {
+			results = append(results, FuzzySearchResult{File: file, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,182 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// shareVisit collects what one shared-link request did and records it as a
+// ShareAccess when the handler returns
+type shareVisit struct {
+	c      *gin.Context
+	access models.ShareAccess
+}
+
+func newShareVisit(c *gin.Context, kind string) *shareVisit {
+	return &shareVisit{c: c, access: models.ShareAccess{
+		Kind:        kind,
+		LinkHash:    utils.HashShareLink(c.Param("token")),
+		VisitorHash: utils.HashVisitor(c.ClientIP()),
+		UserAgent:   truncateString(c.Request.UserAgent(), 512),
+		Referrer:    truncateString(c.Request.Referer(), 1024),
+	}}
+}
+
+func (v *shareVisit) file(file models.File) {
+	v.access.FileID = &file.ID
+	v.access.OwnerID = &file.UserID
+}
+
+func (v *shareVisit) folder(folder models.Folder) {
+	v.access.FolderID = &folder.ID
+	v.access.OwnerID = &folder.UserID
+}
+
+// deny records why the link was refused
+func (v *shareVisit) deny(reason string) {
+	v.access.Denied = reason
+}
+
+func (v *shareVisit) record(db *gorm.DB) {
+	v.access.Status = v.c.Writer.Status()
+	if size := v.c.Writer.Size(); size > 0 {
+		v.access.BytesServed = int64(size)
+	}
+	if err := db.Create(&v.access).Error; err != nil {
+		log.Printf("Error recording share access: %v", err)
+	}
+}
+
+func truncateString(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+type shareTotals struct {
+	Views          int64      `json:"views"`
+	Downloads      int64      `json:"downloads"`
+	UniqueVisitors int64      `json:"unique_visitors"`
+	BytesServed    int64      `json:"bytes_served"`
+	Denied         int64      `json:"denied"`
+	LastAccessed   *time.Time `json:"last_accessed"`
+}
+
+type shareLinkStats struct {
+	Link string `json:"link"`
+	shareTotals
+}
+
+type shareDayStats struct {
+	Day            time.Time `json:"day"`
+	Views          int64     `json:"views"`
+	Downloads      int64     `json:"downloads"`
+	UniqueVisitors int64     `json:"unique_visitors"`
+}
+
+type shareDenial struct {
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// Only successful requests count as views, downloads and visitors
+const shareTotalsSelect = `
+	COUNT(*) FILTER (WHERE kind = 'view' AND denied = '' AND status < 400) AS views,
+	COUNT(*) FILTER (WHERE kind = 'download' AND denied = '' AND status < 400) AS downloads,
+	COUNT(DISTINCT visitor_hash) FILTER (WHERE denied = '' AND status < 400) AS unique_visitors,
+	COALESCE(SUM(bytes_served) FILTER (WHERE denied = '' AND status < 400), 0) AS bytes_served,
+	COUNT(*) FILTER (WHERE denied <> '') AS denied,
+	MAX(created_at) FILTER (WHERE denied = '' AND status < 400) AS last_accessed`
+
+// shareStats summarizes the accesses matching column = id: totals, a
+// breakdown per link, daily counts and the reasons requests were denied
+func shareStats(c *gin.Context, db *gorm.DB, column string, id uint) {
+	from, to, err := usageRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date range, use from and to as YYYY-MM-DD within a year"})
+		return
+	}
+	base := db.Model(&models.ShareAccess{}).
+		Where(column+" = ? AND created_at >= ? AND created_at < ?", id, from, to.AddDate(0, 0, 1)).
+		Session(&gorm.Session{})
+
+	var totals shareTotals
+	var links []shareLinkStats
+	var days []shareDayStats
+	var denials []shareDenial
+	err = base.Select(shareTotalsSelect).Scan(&totals).Error
+	if err == nil {
+		err = base.Select("link_hash AS link, " + shareTotalsSelect).Group("link_hash").
+			Order("last_accessed DESC NULLS LAST").Scan(&links).Error
+	}
+	if err == nil {
+		err = base.Select(`date_trunc('day', created_at) AS day,
+			COUNT(*) FILTER (WHERE kind = 'view' AND denied = '' AND status < 400) AS views,
+			COUNT(*) FILTER (WHERE kind = 'download' AND denied = '' AND status < 400) AS downloads,
+			COUNT(DISTINCT visitor_hash) FILTER (WHERE denied = '' AND status < 400) AS unique_visitors`).
+			Group("day").Order("day").Scan(&days).Error
+	}
+	if err == nil {
+		err = base.Select("denied AS reason, COUNT(*) AS count").Where("denied <> ''").
+			Group("denied").Order("count DESC").Scan(&denials).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch share stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from.Format("2006-01-02"),
+		"to":      to.Format("2006-01-02"),
+		"totals":  totals,
+		"links":   links,
+		"days":    days,
+		"denials": denials,
+	})
+}
+
+// GetFileShareStats reports how a file's share links have been used
+func GetFileShareStats(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		shareStats(c, db, "file_id", file.ID)
+	}
+}
+
+// GetFolderShareStats reports how a folder's share links have been used
+func GetFolderShareStats(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var folder models.Folder
+		if err := db.Where("id = ? AND user_id = ?", c.Param("folder_id"), userID).First(&folder).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		shareStats(c, db, "folder_id", folder.ID)
+	}
+}
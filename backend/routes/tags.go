@@ -0,0 +1,399 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"filesharing/jobs"
+	"filesharing/middleware"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits on tags and custom metadata
+const (
+	maxTagNameLength      = 64
+	maxBulkTagFiles       = 1000
+	maxCustomMetadataKeys = 64
+	maxCustomMetadataSize = 16 << 10
+	defaultTagColor       = "#808080"
+)
+
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type CreateTagRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Color string `json:"color"`
+}
+
+type UpdateTagRequest struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+type BulkTagRequest struct {
+	FileIDs []uint   `json:"file_ids" binding:"required"`
+	Tags    []string `json:"tags" binding:"required"`
+}
+
+// normalizeTagName trims a tag name and rejects empty or overlong names
+func normalizeTagName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && utf8.RuneCountInString(name) <= maxTagNameLength
+}
+
+func CreateTag(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req CreateTagRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		name, ok := normalizeTagName(req.Name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag name"})
+			return
+		}
+		color := req.Color
+		if color == "" {
+			color = defaultTagColor
+		}
+		if !tagColorPattern.MatchString(color) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Color must be a hex value such as #1e90ff"})
+			return
+		}
+
+		tag := models.Tag{UserID: userID.(uint), Name: name, Color: strings.ToLower(color)}
+		if err := db.Create(&tag).Error; err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+			return
+		}
+
+		middleware.SetAuditTarget(c, "tag", tag.ID, tag.UserID)
+		c.JSON(http.StatusCreated, gin.H{"tag": tag})
+	}
+}
+
+func ListTags(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var tags []models.Tag
+		if err := db.Where("user_id = ?", userID).Order("name").Find(&tags).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	}
+}
+
+func UpdateTag(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req UpdateTagRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var tag models.Tag
+		if err := db.Where("id = ? AND user_id = ?", c.Param("tag_id"), userID).First(&tag).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if req.Name != nil {
+			name, ok := normalizeTagName(*req.Name)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag name"})
+				return
+			}
+			updates["name"] = name
+		}
+		if req.Color != nil {
+			if !tagColorPattern.MatchString(*req.Color) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Color must be a hex value such as #1e90ff"})
+				return
+			}
+			updates["color"] = strings.ToLower(*req.Color)
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&tag).Updates(updates).Error; err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+				return
+			}
+			utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tag": tag})
+	}
+}
+
+func DeleteTag(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var tag models.Tag
+		if err := db.Where("id = ? AND user_id = ?", c.Param("tag_id"), userID).First(&tag).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+			return
+		}
+
+		// Detach the tag from every file, then delete it for good so the name can be reused
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("DELETE FROM file_tags WHERE tag_id = ?", tag.ID).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&tag).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+			return
+		}
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+
+		c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+	}
+}
+
+// bindBulkTagRequest validates a bulk request and checks every file belongs to the user
+func bindBulkTagRequest(db *gorm.DB, c *gin.Context, userID interface{}) (*BulkTagRequest, bool) {
+	var req BulkTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	middleware.AddAuditDetail(c, "file_ids", req.FileIDs)
+	middleware.AddAuditDetail(c, "tags", req.Tags)
+	if len(req.FileIDs) == 0 || len(req.Tags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file_ids and tags must not be empty"})
+		return nil, false
+	}
+	if len(req.FileIDs) > maxBulkTagFiles {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d files per request", maxBulkTagFiles)})
+		return nil, false
+	}
+	for i, name := range req.Tags {
+		normalized, ok := normalizeTagName(name)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag name"})
+			return nil, false
+		}
+		req.Tags[i] = normalized
+	}
+
+	var owned int64
+	err := db.Model(&models.File{}).Where("id IN ? AND user_id = ?", req.FileIDs, userID).Distinct("id").Count(&owned).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		return nil, false
+	}
+	if owned != int64(len(uniqueIDs(req.FileIDs))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "One or more files not found"})
+		return nil, false
+	}
+	return &req, true
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var unique []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// TagFiles attaches tags to files, creating tags that do not exist yet
+func TagFiles(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		req, ok := bindBulkTagRequest(db, c, userID)
+		if !ok {
+			return
+		}
+
+		var tags []models.Tag
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			tags, err = ensureTags(tx, userID.(uint), req.Tags)
+			if err != nil {
+				return err
+			}
+			return tagFiles(tx, uniqueIDs(req.FileIDs), tags)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to tag files"})
+			return
+		}
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+		for _, id := range uniqueIDs(req.FileIDs) {
+			jobs.EmitEvent(db, userID.(uint), models.EventFileUpdated, fileUpdateEvent(id, "tags"))
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tags": tags, "files": len(uniqueIDs(req.FileIDs))})
+	}
+}
+
+// UntagFiles detaches tags from files; unknown tag names are ignored
+func UntagFiles(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		req, ok := bindBulkTagRequest(db, c, userID)
+		if !ok {
+			return
+		}
+
+		result := db.Exec(`DELETE FROM file_tags WHERE file_id IN ? AND tag_id IN
+			(SELECT id FROM tags WHERE user_id = ? AND lower(name) IN ? AND deleted_at IS NULL)`,
+			req.FileIDs, userID, lowerNames(req.Tags))
+		if result.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to untag files"})
+			return
+		}
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+		if result.RowsAffected > 0 {
+			for _, id := range uniqueIDs(req.FileIDs) {
+				jobs.EmitEvent(db, userID.(uint), models.EventFileUpdated, fileUpdateEvent(id, "tags"))
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"removed": result.RowsAffected})
+	}
+}
+
+// ensureTags returns the user's tags with the given names, matched without
+// regard to case, creating missing ones
+func ensureTags(tx *gorm.DB, userID uint, names []string) ([]models.Tag, error) {
+	var tags []models.Tag
+	if err := tx.Where("user_id = ? AND lower(name) IN ?", userID, lowerNames(names)).Find(&tags).Error; err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		found[strings.ToLower(tag.Name)] = true
+	}
+	for _, name := range names {
+		if found[strings.ToLower(name)] {
+			continue
+		}
+		tag := models.Tag{UserID: userID, Name: name, Color: defaultTagColor}
+		if err := tx.Create(&tag).Error; err != nil {
+			return nil, err
+		}
+		found[strings.ToLower(name)] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func lowerNames(names []string) []string {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	return lower
+}
+
+// tagFiles inserts the file_tags rows, skipping pairs that already exist
+func tagFiles(tx *gorm.DB, fileIDs []uint, tags []models.Tag) error {
+	rows := make([]map[string]interface{}, 0, len(fileIDs)*len(tags))
+	for _, fileID := range fileIDs {
+		for _, tag := range tags {
+			rows = append(rows, map[string]interface{}{"file_id": fileID, "tag_id": tag.ID})
+		}
+	}
+	return tx.Table("file_tags").Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 500).Error
+}
+
+// UpdateCustomMetadata replaces a file's custom key/value metadata
+func UpdateCustomMetadata(db *gorm.DB, cache utils.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		body, err := c.GetRawData()
+		if err != nil || len(body) > maxCustomMetadataSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Metadata must be a JSON object of at most %d bytes", maxCustomMetadataSize)})
+			return
+		}
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(body, &metadata); err != nil || metadata == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Metadata must be a JSON object"})
+			return
+		}
+		if len(metadata) > maxCustomMetadataKeys {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d metadata keys", maxCustomMetadataKeys)})
+			return
+		}
+		for key := range metadata {
+			if !utils.ValidMetadataKey(key) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid metadata key %q", key)})
+				return
+			}
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", c.Param("file_id"), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		file.CustomMetadata = metadata
+		if err := db.Model(&file).Select("CustomMetadata").Updates(&file).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update metadata"})
+			return
+		}
+		utils.InvalidateFileListings(c.Request.Context(), cache, userID.(uint))
+		jobs.EmitEvent(db, file.UserID, models.EventFileUpdated, fileUpdateEvent(file.ID, "custom_metadata"))
+
+		c.JSON(http.StatusOK, gin.H{"custom_metadata": file.CustomMetadata})
+	}
+}
@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"filesharing/jobs"
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetThumbnail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		fileIDUint, err := strconv.ParseUint(c.Param("file_id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID format"})
+			return
+		}
+
+		size := c.DefaultQuery("size", "medium")
+		if _, ok := jobs.ThumbnailSizes[size]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid thumbnail size"})
+			return
+		}
+
+		var file models.File
+		if err := db.Where("id = ? AND user_id = ?", uint(fileIDUint), userID).First(&file).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+
+		if !utils.Thumbnailable(file.MimeType) {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Thumbnails are not available for this file type"})
+			return
+		}
+
+		switch file.ProcessingSteps[models.StepThumbnails] {
+		case models.StepSkipped:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnails are not available for this file"})
+			return
+		case models.StepFailed:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Thumbnails could not be generated for this file"})
+			return
+		}
+
+		var thumb models.Thumbnail
+		if err := db.Where("file_id = ? AND size = ?", file.ID, size).First(&thumb).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thumbnail"})
+				return
+			}
+
+			// Not generated yet, queue it and let the client retry
+			if err := jobs.EnqueueThumbnails(db, file.ID); err != nil {
+				log.Printf("Error queueing thumbnails for file %d: %v", file.ID, err)
+			}
+			c.Header("Retry-After", "5")
+			c.JSON(http.StatusAccepted, gin.H{"message": "Thumbnail is being generated"})
+			return
+		}
+
+		etag := fmt.Sprintf(`"%d-%s-%d"`, file.ID, size, thumb.UpdatedAt.Unix())
+		c.Header("Cache-Control", "private, max-age=86400")
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		// Initialize S3 client
+		s3Client, err := utils.NewS3Client()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize S3 client"})
+			return
+		}
+
+		obj, err := s3Client.GetObject(c.Request.Context(), thumb.Key)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnail not found"})
+			return
+		}
+		defer obj.Body.Close()
+
+		c.DataFromReader(http.StatusOK, aws.ToInt64(obj.ContentLength), thumb.ContentType, obj.Body, nil)
+	}
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// transfer meters one download: it throttles the stream against the owner's
+// or the share link's bandwidth limit and charges the bytes to the owner.
+type transfer struct {
+	userID uint
+	shared bool
+	meter  *utils.Meter
+}
+
+// ownerTransfer is a user downloading their own files
+func ownerTransfer(ctx context.Context, userID uint) *transfer {
+	return &transfer{
+		userID: userID,
+		meter: utils.NewMeter(ctx, utils.BandwidthLimit{
+			Key:            fmt.Sprintf("user:%d", userID),
+			BytesPerSecond: utils.EnvSize("BANDWIDTH_PER_USER", 0),
+		}),
+	}
+}
+
+// sharedTransfer is anyone downloading through a share link. Every
+// download of the same link shares the link's limit.
+func sharedTransfer(ctx context.Context, ownerID uint, link string) *transfer {
+	return &transfer{
+		userID: ownerID,
+		shared: true,
+		meter: utils.NewMeter(ctx, utils.BandwidthLimit{
+			Key:            "share:" + link,
+			BytesPerSecond: utils.EnvSize("BANDWIDTH_PER_SHARE", 0),
+		}),
+	}
+}
+
+// record charges the bytes sent so far to the owner's usage for today
+func (t *transfer) record(db *gorm.DB) {
+	n := t.meter.Bytes()
+	if t.shared {
+		recordUsage(db, t.userID, models.TransferUsage{SharedBytes: n})
+	} else {
+		recordUsage(db, t.userID, models.TransferUsage{DownloadedBytes: n})
+	}
+}
+
+// recordUsage adds the byte counts in delta to the user's row for today
+func recordUsage(db *gorm.DB, userID uint, delta models.TransferUsage) {
+	if delta.UploadedBytes == 0 && delta.DownloadedBytes == 0 && delta.SharedBytes == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	delta.UserID = userID
+	delta.Day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	delta.UpdatedAt = now
+
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"uploaded_bytes":   gorm.Expr("transfer_usages.uploaded_bytes + EXCLUDED.uploaded_bytes"),
+			"downloaded_bytes": gorm.Expr("transfer_usages.downloaded_bytes + EXCLUDED.downloaded_bytes"),
+			"shared_bytes":     gorm.Expr("transfer_usages.shared_bytes + EXCLUDED.shared_bytes"),
+			"updated_at":       now,
+		}),
+	}).Create(&delta).Error
+	if err != nil {
+		log.Printf("Error recording transfer usage for user %d: %v", userID, err)
+	}
+}
+
+type usageTotals struct {
+	UserID          uint  `json:"user_id,omitempty"`
+	UploadedBytes   int64 `json:"uploaded_bytes"`
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	SharedBytes     int64 `json:"shared_bytes"`
+}
+
+const usageTotalsSelect = "COALESCE(SUM(uploaded_bytes), 0) AS uploaded_bytes, " +
+	"COALESCE(SUM(downloaded_bytes), 0) AS downloaded_bytes, COALESCE(SUM(shared_bytes), 0) AS shared_bytes"
+
+// usageRange reads ?from= and ?to= (YYYY-MM-DD, inclusive), defaulting to
+// the last 30 days
+func usageRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -29)
+
+	var err error
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, err
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, err
+		}
+	}
+	if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+		return from, to, fmt.Errorf("invalid range")
+	}
+	return from, to, nil
+}
+
+// GetUsage returns the current user's transfer usage per day and in total
+func GetUsage(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		from, to, err := usageRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date range, use from and to as YYYY-MM-DD within a year"})
+			return
+		}
+
+		query := db.Model(&models.TransferUsage{}).Where("user_id = ? AND day BETWEEN ? AND ?", userID, from, to)
+
+		var days []models.TransferUsage
+		if err := query.Session(&gorm.Session{}).Order("day").Find(&days).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+		var totals usageTotals
+		if err := query.Select(usageTotalsSelect).Scan(&totals).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":   from.Format("2006-01-02"),
+			"to":     to.Format("2006-01-02"),
+			"days":   days,
+			"totals": totals,
+		})
+	}
+}
+
+// AdminUsage returns transfer totals per user over a date range, heaviest
+// first, or one user's totals with ?user_id=
+func AdminUsage(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := usageRange(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date range, use from and to as YYYY-MM-DD within a year"})
+			return
+		}
+
+		query := db.Model(&models.TransferUsage{}).Where("day BETWEEN ? AND ?", from, to)
+		if userID := c.Query("user_id"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+
+		var users []usageTotals
+		if err := query.Select("user_id, " + usageTotalsSelect).Group("user_id").
+			Order("SUM(uploaded_bytes + downloaded_bytes + shared_bytes) DESC").Limit(500).Scan(&users).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":  from.Format("2006-01-02"),
+			"to":    to.Format("2006-01-02"),
+			"users": users,
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"filesharing/models"
+	"filesharing/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UpdateSettingsRequest struct {
+	StripSharedMetadata *bool `json:"strip_shared_metadata"`
+	// Default expiry for new uploads such as "7d", or "never"
+	DefaultExpiresIn *string `json:"default_expires_in"`
+}
+
+func GetCurrentUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+func UpdateSettings(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req UpdateSettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if req.StripSharedMetadata != nil {
+			updates["strip_shared_metadata"] = *req.StripSharedMetadata
+		}
+		if req.DefaultExpiresIn != nil {
+			seconds := int64(0)
+			if v := strings.TrimSpace(*req.DefaultExpiresIn); v != "" && !strings.EqualFold(v, "never") {
+				ttl, err := utils.ParseTTL(v)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid default_expires_in: " + err.Error()})
+					return
+				}
+				seconds = int64(ttl / time.Second)
+			}
+			updates["default_expiry_seconds"] = seconds
+		}
+		if len(updates) > 0 {
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
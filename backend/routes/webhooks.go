@@ -0,0 +1,353 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"filesharing/jobs"
+	"filesharing/middleware"
+	"filesharing/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxWebhooks caps the subscriptions one user can create
+const maxWebhooks = 20
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+type UpdateWebhookRequest struct {
+	URL          *string  `json:"url"`
+	Events       []string `json:"events"`
+	Description  *string  `json:"description"`
+	Active       *bool    `json:"active"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+// validWebhookURL accepts absolute http and https URLs
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && len(raw) <= 2048
+}
+
+// validWebhookEvents accepts known events, "*" and group wildcards such as "file.*"
+func validWebhookEvents(events []string) bool {
+	for _, e := range events {
+		if e == "*" || slices.Contains(models.WebhookEvents, e) {
+			continue
+		}
+		prefix, ok := strings.CutSuffix(e, ".*")
+		if !ok || !slices.ContainsFunc(models.WebhookEvents, func(known string) bool {
+			return strings.HasPrefix(known, prefix+".")
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// findWebhook loads one of the user's webhooks by the :webhook_id parameter
+func findWebhook(db *gorm.DB, c *gin.Context, userID interface{}) (*models.Webhook, bool) {
+	var hook models.Webhook
+	if err := db.Where("id = ? AND user_id = ?", c.Param("webhook_id"), userID).First(&hook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return nil, false
+	}
+	middleware.SetAuditTarget(c, "webhook", hook.ID, hook.UserID)
+	return &hook, true
+}
+
+// CreateWebhook subscribes a URL to events. The signing secret is only
+// returned here and when it is rotated.
+func CreateWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req CreateWebhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !validWebhookURL(req.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http or https URL"})
+			return
+		}
+		if !validWebhookEvents(req.Events) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event", "events": models.WebhookEvents})
+			return
+		}
+
+		var count int64
+		db.Model(&models.Webhook{}).Where("user_id = ?", userID).Count(&count)
+		if count >= maxWebhooks {
+			c.JSON(http.StatusConflict, gin.H{"error": "Webhook limit reached"})
+			return
+		}
+
+		secret, err := newWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+			return
+		}
+		hook := models.Webhook{
+			UserID:      userID.(uint),
+			URL:         req.URL,
+			Description: strings.TrimSpace(req.Description),
+			Events:      req.Events,
+			Secret:      secret,
+			Active:      true,
+		}
+		if err := db.Create(&hook).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+			return
+		}
+
+		middleware.SetAuditTarget(c, "webhook", hook.ID, hook.UserID)
+		c.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": secret})
+	}
+}
+
+func ListWebhooks(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var hooks []models.Webhook
+		if err := db.Where("user_id = ?", userID).Order("id").Find(&hooks).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "events": models.WebhookEvents})
+	}
+}
+
+func GetWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		hook, ok := findWebhook(db, c, userID)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"webhook": hook})
+	}
+}
+
+func UpdateWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		var req UpdateWebhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hook, ok := findWebhook(db, c, userID)
+		if !ok {
+			return
+		}
+
+		var fields []string
+		if req.URL != nil {
+			if !validWebhookURL(*req.URL) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http or https URL"})
+				return
+			}
+			hook.URL = *req.URL
+			fields = append(fields, "URL")
+		}
+		if req.Events != nil {
+			if !validWebhookEvents(req.Events) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event", "events": models.WebhookEvents})
+				return
+			}
+			hook.Events = req.Events
+			fields = append(fields, "Events")
+		}
+		if req.Description != nil {
+			hook.Description = strings.TrimSpace(*req.Description)
+			fields = append(fields, "Description")
+		}
+		if req.Active != nil {
+			hook.Active = *req.Active
+			fields = append(fields, "Active")
+		}
+		var secret string
+		if req.RotateSecret {
+			var err error
+			if secret, err = newWebhookSecret(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+				return
+			}
+			hook.Secret = secret
+			fields = append(fields, "Secret")
+		}
+		if len(fields) > 0 {
+			if err := db.Model(hook).Select(fields).Updates(hook).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+				return
+			}
+		}
+
+		response := gin.H{"webhook": hook}
+		if secret != "" {
+			response["secret"] = secret
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DeleteWebhook removes a webhook; queued deliveries to it are dropped
+func DeleteWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		hook, ok := findWebhook(db, c, userID)
+		if !ok {
+			return
+		}
+		if err := db.Delete(hook).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+	}
+}
+
+// TestWebhook sends a webhook.test event right away, even to a disabled
+// webhook, and returns the delivery so the receiver can be debugged
+func TestWebhook(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		hook, ok := findWebhook(db, c, userID)
+		if !ok {
+			return
+		}
+
+		event, err := json.Marshal(jobs.NewWebhookEvent(hook.UserID, models.EventWebhookTest, gin.H{"webhook_id": hook.ID}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build test event"})
+			return
+		}
+		delivery := jobs.SendWebhook(c.Request.Context(), db, hook, event)
+		middleware.AddAuditDetail(c, "success", delivery.Success)
+
+		c.JSON(http.StatusOK, gin.H{"delivery": delivery})
+	}
+}
+
+// ListWebhookDeliveries returns a page of delivery attempts, newest first;
+// older pages follow with ?before_id=
+func ListWebhookDeliveries(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		hook, ok := findWebhook(db, c, userID)
+		if !ok {
+			return
+		}
+
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 || limit > 500 {
+			limit = 50
+		}
+		query := db.Where("webhook_id = ?", hook.ID)
+		if before := c.Query("before_id"); before != "" {
+			query = query.Where("id < ?", before)
+		}
+		switch c.Query("success") {
+		case "true":
+			query = query.Where("success")
+		case "false":
+			query = query.Where("NOT success")
+		}
+
+		var deliveries []models.WebhookDelivery
+		if err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries"})
+			return
+		}
+
+		response := gin.H{"deliveries": deliveries}
+		if len(deliveries) == limit {
+			response["next_before_id"] = deliveries[len(deliveries)-1].ID
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// fileEvent is the data of file webhook events
+func fileEvent(file *models.File) gin.H {
+	return gin.H{
+		"file_id":       file.ID,
+		"folder_id":     file.FolderID,
+		"original_name": file.OriginalName,
+		"size":          file.Size,
+		"mime_type":     file.MimeType,
+		"sha256":        file.ChecksumSHA256,
+	}
+}
+
+// fileUpdateEvent is the data of file.updated events, naming what changed
+func fileUpdateEvent(fileID uint, changes ...string) gin.H {
+	return gin.H{"file_id": fileID, "changes": changes}
+}
+
+// folderEvent is the data of folder webhook events
+func folderEvent(folder *models.Folder) gin.H {
+	return gin.H{
+		"folder_id": folder.ID,
+		"name":      folder.Name,
+		"path":      folder.Path,
+	}
+}
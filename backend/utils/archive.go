@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats ExtractArchive can unpack
+const (
+	ArchiveZip     = "zip"
+	ArchiveTar     = "tar"
+	ArchiveTarGzip = "tar.gz"
+)
+
+// ErrUnsafeArchivePath is returned for entries that would land outside the
+// extraction folder, such as "../../etc/passwd" or "/abs/path" (zip slip).
+var ErrUnsafeArchivePath = errors.New("unsafe path in archive")
+
+// ArchiveKind reports which archive format a file is, or "" if none
+func ArchiveKind(mimeType, filename string) string {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveTarGzip
+	case strings.HasSuffix(name, ".tar"):
+		return ArchiveTar
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveZip
+	}
+
+	switch strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0])) {
+	case "application/zip", "application/x-zip-compressed":
+		return ArchiveZip
+	case "application/x-tar":
+		return ArchiveTar
+	case "application/gzip", "application/x-gzip", "application/x-compressed-tar":
+		return ArchiveTarGzip
+	}
+	return ""
+}
+
+// ArchiveEntry describes one member of an archive
+type ArchiveEntry struct {
+	Name    string
+	Dir     bool
+	Regular bool
+	Size    int64 // as declared by the archive, which may lie
+}
+
+// WalkArchive calls fn for every entry of the archive in f. For regular
+// files body yields the decompressed content; it is nil otherwise.
+func WalkArchive(f *os.File, kind string, fn func(entry ArchiveEntry, body io.Reader) error) error {
+	switch kind {
+	case ArchiveZip:
+		return walkZip(f, fn)
+	case ArchiveTar:
+		return walkTar(f, fn)
+	case ArchiveTarGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(gz, fn)
+	}
+	return errors.New("unsupported archive format")
+}
+
+func walkZip(f *os.File, fn func(ArchiveEntry, io.Reader) error) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		entry := ArchiveEntry{
+			Name:    zf.Name,
+			Dir:     mode.IsDir(),
+			Regular: mode.IsRegular(),
+			Size:    int64(zf.UncompressedSize64),
+		}
+		if !entry.Regular {
+			if err := fn(entry, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(entry, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, fn func(ArchiveEntry, io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := ArchiveEntry{
+			Name:    hdr.Name,
+			Dir:     hdr.Typeflag == tar.TypeDir,
+			Regular: hdr.Typeflag == tar.TypeReg,
+			Size:    hdr.Size,
+		}
+		var body io.Reader
+		if entry.Regular {
+			body = tr
+		}
+		if err := fn(entry, body); err != nil {
+			return err
+		}
+	}
+}
+
+// SafeArchivePath cleans an entry name into a relative slash separated path,
+// rejecting absolute paths, drive letters and ".." components.
+func SafeArchivePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" || (len(name) > 1 && name[1] == ':') {
+		return "", ErrUnsafeArchivePath
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return "", ErrUnsafeArchivePath
+		}
+		if strings.ContainsRune(part, 0) {
+			return "", ErrUnsafeArchivePath
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "", ErrUnsafeArchivePath
+	}
+	return strings.Join(parts, "/"), nil
+}
@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func TestSafeArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"report.pdf", "report.pdf", true},
+		{"docs/2026/report.pdf", "docs/2026/report.pdf", true},
+		{"./docs//report.pdf", "docs/report.pdf", true},
+		{"docs/", "docs", true},
+		{`docs\report.pdf`, "docs/report.pdf", true},
+		{"/etc/passwd", "", false},
+		{`\windows\system32`, "", false},
+		{"C:/windows", "", false},
+		{`c:report.pdf`, "", false},
+		{"../secret", "", false},
+		{"docs/../../secret", "", false},
+		{`docs\..\secret`, "", false},
+		{"docs/a\x00b", "", false},
+		{"", "", false},
+		{"./", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := SafeArchivePath(tt.name)
+		if tt.ok {
+			if err != nil || got != tt.want {
+				t.Errorf("SafeArchivePath(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+			}
+		} else if err != ErrUnsafeArchivePath {
+			t.Errorf("SafeArchivePath(%q) = %q, %v, want ErrUnsafeArchivePath", tt.name, got, err)
+		}
+	}
+}
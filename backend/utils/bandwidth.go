@@ -0,0 +1,203 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk bounds how much is read or written between waits, so a
+// throttled stream flows steadily instead of in bursts
+const throttleChunk = 32 << 10
+
+// BandwidthLimit caps the combined throughput of every transfer sharing Key
+// in this process. Buckets are not shared between instances, so each
+// replica allows the full rate on its own.
+type BandwidthLimit struct {
+	Key            string
+	BytesPerSecond int64
+}
+
+// bandwidthBucket is a token bucket of bytes. Transfers reserve what they
+// just moved and sleep off any debt, so concurrent streams share the rate.
+type bandwidthBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	lastUsed time.Time
+}
+
+// bucketIdleTime is how long an unused bucket is kept
+const bucketIdleTime = time.Minute
+
+var (
+	bucketsMu sync.Mutex
+	buckets   = map[string]*bandwidthBucket{}
+	sweepOnce sync.Once
+)
+
+// bucketFor returns the process-wide bucket for a limit
+func bucketFor(limit BandwidthLimit) *bandwidthBucket {
+	sweepOnce.Do(func() { go sweepBuckets() })
+
+	now := time.Now()
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+
+	rate := float64(limit.BytesPerSecond)
+	b, ok := buckets[limit.Key]
+	if !ok || b.rate != rate {
+		burst := rate
+		if burst < throttleChunk {
+			burst = throttleChunk
+		}
+		b = &bandwidthBucket{rate: rate, burst: burst, tokens: burst, last: now, lastUsed: now}
+		buckets[limit.Key] = b
+	}
+	return b
+}
+
+// sweepBuckets drops buckets that have been idle for a while
+func sweepBuckets() {
+	ticker := time.NewTicker(bucketIdleTime)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		bucketsMu.Lock()
+		for key, b := range buckets {
+			b.mu.Lock()
+			idle := now.Sub(b.lastUsed) > bucketIdleTime
+			b.mu.Unlock()
+			if idle {
+				delete(buckets, key)
+			}
+		}
+		bucketsMu.Unlock()
+	}
+}
+
+// wait charges n bytes to the bucket and sleeps until the debt is repaid
+func (b *bandwidthBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.lastUsed = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Meter throttles a stream against its bandwidth limits and counts the
+// bytes that went through it
+type Meter struct {
+	ctx     context.Context
+	buckets []*bandwidthBucket
+	n       int64
+	mu      sync.Mutex
+}
+
+// NewMeter applies every limit with a positive rate; others are ignored
+func NewMeter(ctx context.Context, limits ...BandwidthLimit) *Meter {
+	m := &Meter{ctx: ctx}
+	for _, limit := range limits {
+		if limit.BytesPerSecond > 0 {
+			m.buckets = append(m.buckets, bucketFor(limit))
+		}
+	}
+	return m
+}
+
+// Bytes returns how many bytes have gone through the meter
+func (m *Meter) Bytes() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.n
+}
+
+func (m *Meter) charge(n int) error {
+	m.mu.Lock()
+	m.n += int64(n)
+	m.mu.Unlock()
+	for _, b := range m.buckets {
+		if err := b.wait(m.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader wraps r so reads are metered
+func (m *Meter) Reader(r io.Reader) io.Reader {
+	return &meteredReader{r: r, m: m}
+}
+
+// Writer wraps w so writes are metered
+func (m *Meter) Writer(w io.Writer) io.Writer {
+	return &meteredWriter{w: w, m: m}
+}
+
+type meteredReader struct {
+	r io.Reader
+	m *Meter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	if len(r.m.buckets) > 0 && len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.m.charge(n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type meteredWriter struct {
+	w io.Writer
+	m *Meter
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(w.m.buckets) > 0 && len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if n > 0 {
+			if werr := w.m.charge(n); werr != nil && err == nil {
+				err = werr
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,302 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCacheMiss is returned by Get when the key is absent or expired
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache is a string key-value store with expiry. Values are advisory:
+// callers must cope with misses and with errors by going to the database.
+type Cache interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	DeleteByPrefix(ctx context.Context, prefix string) error
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// NewCache picks the cache backend from CACHE_BACKEND. "memory" keeps
+// everything in process; "redis" (the default) shares the cache between
+// instances and falls back to memory while Redis is unreachable. The Redis
+// client is returned for the features that need Redis itself, and is nil
+// for the memory backend. err reports that Redis could not be reached at
+// startup; the client is still returned so the cache can recover.
+func NewCache() (cache Cache, client *redis.Client, err error) {
+	size := int(EnvInt64("CACHE_MAX_ENTRIES", 10000))
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "memory":
+		return NewMemoryCache(size), nil, nil
+	case "", "redis":
+	default:
+		log.Printf("Warning: unknown CACHE_BACKEND %q, using redis", backend)
+	}
+
+	client, err = NewRedisClient()
+	if err != nil {
+		log.Printf("Warning: failed to connect to Redis, caching in memory: %v", err)
+	}
+	return NewFallbackCache(NewRedisCache(client), NewMemoryCache(size)), client, err
+}
+
+// fallbackRetryInterval is how long the primary is skipped after a failure,
+// so requests don't each wait for a connection timeout during an outage
+const fallbackRetryInterval = 10 * time.Second
+
+// maxMissedKeys bounds the invalidations remembered during an outage; past
+// it the whole cache namespace is dropped on recovery instead
+const maxMissedKeys = 10000
+
+// FallbackCache serves from primary and switches to fallback while primary
+// fails, so the service degrades instead of failing when Redis is down.
+// Invalidations that could not reach the primary are replayed on it when it
+// comes back, so entries cached before the outage are not served stale.
+type FallbackCache struct {
+	primary  Cache
+	fallback Cache
+	down     atomic.Bool
+	retryAt  atomic.Int64
+
+	mu             sync.Mutex
+	missedKeys     map[string]bool
+	missedPrefixes map[string]bool
+	missedAll      bool
+}
+
+func NewFallbackCache(primary, fallback Cache) *FallbackCache {
+	return &FallbackCache{primary: primary, fallback: fallback}
+}
+
+func (f *FallbackCache) Get(ctx context.Context, key string) (string, error) {
+	if f.usePrimary() {
+		value, err := f.primary.Get(ctx, key)
+		if f.ok(err) {
+			return value, err
+		}
+	}
+	return f.fallback.Get(ctx, key)
+}
+
+func (f *FallbackCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if f.usePrimary() {
+		if err := f.primary.Set(ctx, key, value, ttl); f.ok(err) {
+			return nil
+		}
+	}
+	return f.fallback.Set(ctx, key, value, ttl)
+}
+
+// Delete removes the key from both caches so nothing stale is left behind
+// in memory for the next outage
+func (f *FallbackCache) Delete(ctx context.Context, key string) error {
+	f.fallback.Delete(ctx, key)
+	if f.usePrimary() {
+		if err := f.primary.Delete(ctx, key); f.ok(err) {
+			return nil
+		}
+	}
+	f.miss(key, false)
+	return nil
+}
+
+func (f *FallbackCache) DeleteByPrefix(ctx context.Context, prefix string) error {
+	f.fallback.DeleteByPrefix(ctx, prefix)
+	if f.usePrimary() {
+		if err := f.primary.DeleteByPrefix(ctx, prefix); f.ok(err) {
+			return nil
+		}
+	}
+	f.miss(prefix, true)
+	return nil
+}
+
+// Incr falls back to counting in memory. The primary's counter is deleted
+// once it is back, which for version counters moves readers to fresh keys.
+func (f *FallbackCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if f.usePrimary() {
+		n, err := f.primary.Incr(ctx, key, ttl)
+		if f.ok(err) {
+			return n, err
+		}
+	}
+	f.miss(key, false)
+	return f.fallback.Incr(ctx, key, ttl)
+}
+
+// miss remembers a key or prefix the primary has to drop once it is back
+func (f *FallbackCache) miss(key string, prefix bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.missedAll {
+		return
+	}
+	if f.missedKeys == nil {
+		f.missedKeys = make(map[string]bool)
+		f.missedPrefixes = make(map[string]bool)
+	}
+	if prefix {
+		f.missedPrefixes[key] = true
+	} else {
+		f.missedKeys[key] = true
+	}
+	if len(f.missedKeys)+len(f.missedPrefixes) > maxMissedKeys {
+		f.missedAll = true
+		f.missedKeys, f.missedPrefixes = nil, nil
+	}
+}
+
+// resync replays the missed invalidations on the primary and empties the
+// fallback, so the next outage does not start from entries kept in memory
+// while the primary was serving
+func (f *FallbackCache) resync() {
+	f.mu.Lock()
+	keys, prefixes, all := f.missedKeys, f.missedPrefixes, f.missedAll
+	f.missedKeys, f.missedPrefixes, f.missedAll = nil, nil, false
+	f.mu.Unlock()
+
+	ctx := context.Background()
+	if all {
+		prefixes = map[string]bool{cachePrefix: true}
+		keys = nil
+	}
+	for prefix := range prefixes {
+		if err := f.primary.DeleteByPrefix(ctx, prefix); !f.ok(err) {
+			f.miss(prefix, true)
+		}
+	}
+	for key := range keys {
+		if err := f.primary.Delete(ctx, key); !f.ok(err) {
+			f.miss(key, false)
+		}
+	}
+	f.fallback.DeleteByPrefix(ctx, cachePrefix)
+}
+
+func (f *FallbackCache) usePrimary() bool {
+	return time.Now().UnixNano() >= f.retryAt.Load()
+}
+
+// ok reports whether the primary answered, logging when it goes away and
+// comes back so the fallback is visible without flooding the log
+func (f *FallbackCache) ok(err error) bool {
+	if err == nil || errors.Is(err, ErrCacheMiss) {
+		if f.down.CompareAndSwap(true, false) {
+			log.Printf("Cache backend is reachable again, leaving the in-memory cache")
+			go f.resync()
+		}
+		return true
+	}
+	f.retryAt.Store(time.Now().Add(fallbackRetryInterval).UnixNano())
+	if f.down.CompareAndSwap(false, true) {
+		log.Printf("Warning: cache backend unavailable, caching in memory: %v", err)
+	}
+	return false
+}
+
+// Cached listings live under a per-user version number. A mutation bumps the
+// version instead of hunting down every cached page, so readers immediately
+// move to fresh keys and the old entries simply expire.
+const cachePrefix = "fs:v1"
+
+// ListCacheTTL is how long a cached listing may be served
+func ListCacheTTL() time.Duration {
+	return EnvDuration("LIST_CACHE_TTL", 5*time.Minute)
+}
+
+func userCachePrefix(userID uint) string {
+	return fmt.Sprintf("%s:user:%d:", cachePrefix, userID)
+}
+
+func fileListVersionKey(userID uint) string {
+	return userCachePrefix(userID) + "files:version"
+}
+
+func folderListVersionKey(userID uint) string {
+	return userCachePrefix(userID) + "folders:version"
+}
+
+// FileListCacheKey returns the key for one page of a user's file listing
+func FileListCacheKey(ctx context.Context, cache Cache, userID uint, query string, page PageRequest) string {
+	params := strings.Join([]string{query, page.Sort, page.Order, strconv.Itoa(page.Limit), page.Cursor}, "\x00")
+	sum := sha256.Sum256([]byte(params))
+	version := cacheVersion(ctx, cache, fileListVersionKey(userID))
+	return fmt.Sprintf("%sfiles:%d:list:%x", userCachePrefix(userID), version, sum[:12])
+}
+
+// FolderListCacheKey returns the key for a user's folder tree
+func FolderListCacheKey(ctx context.Context, cache Cache, userID uint) string {
+	version := cacheVersion(ctx, cache, folderListVersionKey(userID))
+	return fmt.Sprintf("%sfolders:%d", userCachePrefix(userID), version)
+}
+
+// InvalidateFileListings drops every cached file listing of a user. Call it
+// after any change to a file that shows up in listings.
+func InvalidateFileListings(ctx context.Context, cache Cache, userID uint) {
+	bumpVersion(ctx, cache, fileListVersionKey(userID))
+}
+
+// InvalidateFolderListings drops the cached folder tree of a user
+func InvalidateFolderListings(ctx context.Context, cache Cache, userID uint) {
+	bumpVersion(ctx, cache, folderListVersionKey(userID))
+}
+
+// ClearUserCache drops everything cached for one user
+func ClearUserCache(ctx context.Context, cache Cache, userID uint) error {
+	return cache.DeleteByPrefix(ctx, userCachePrefix(userID))
+}
+
+// ReadThrough returns the value cached under key, or loads it and caches the
+// result for ttl. Cache failures never fail the request.
+func ReadThrough[T any](ctx context.Context, cache Cache, key string, ttl time.Duration, load func() (T, error)) (T, error) {
+	if cached, err := cache.Get(ctx, key); err == nil {
+		var value T
+		if err := json.Unmarshal([]byte(cached), &value); err == nil {
+			return value, nil
+		}
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	if data, err := json.Marshal(value); err == nil {
+		cache.Set(ctx, key, string(data), ttl)
+	}
+	return value, nil
+}
+
+// cacheVersion reads a version counter. A missing counter is seeded with the
+// current time so one that was evicted never restarts at a number used
+// before; a racing reader seeding its own value only costs a cache miss.
+func cacheVersion(ctx context.Context, cache Cache, key string) int64 {
+	if v, err := cache.Get(ctx, key); err == nil {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	}
+	seed := time.Now().UnixNano()
+	cache.Set(ctx, key, strconv.FormatInt(seed, 10), 0)
+	return seed
+}
+
+func bumpVersion(ctx context.Context, cache Cache, key string) {
+	n, err := cache.Incr(ctx, key, 0)
+	if err != nil || n == 1 {
+		// The counter was missing or unreadable; reseed rather than reuse 1
+		cache.Set(ctx, key, strconv.FormatInt(time.Now().UnixNano(), 10), 0)
+	}
+}
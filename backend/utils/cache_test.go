@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errCacheDown = errors.New("connection refused")
+
+// flakyCache is a MemoryCache that fails every call while down is set
+type flakyCache struct {
+	*MemoryCache
+	down atomic.Bool
+}
+
+func (c *flakyCache) err() error {
+	if c.down.Load() {
+		return errCacheDown
+	}
+	return nil
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) (string, error) {
+	if err := c.err(); err != nil {
+		return "", err
+	}
+	return c.MemoryCache.Get(ctx, key)
+}
+
+func (c *flakyCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := c.err(); err != nil {
+		return err
+	}
+	return c.MemoryCache.Set(ctx, key, value, ttl)
+}
+
+func (c *flakyCache) Delete(ctx context.Context, key string) error {
+	if err := c.err(); err != nil {
+		return err
+	}
+	return c.MemoryCache.Delete(ctx, key)
+}
+
+func (c *flakyCache) DeleteByPrefix(ctx context.Context, prefix string) error {
+	if err := c.err(); err != nil {
+		return err
+	}
+	return c.MemoryCache.DeleteByPrefix(ctx, prefix)
+}
+
+func (c *flakyCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if err := c.err(); err != nil {
+		return 0, err
+	}
+	return c.MemoryCache.Incr(ctx, key, ttl)
+}
+
+func newTestFallbackCache() (*FallbackCache, *flakyCache, *MemoryCache) {
+	primary := &flakyCache{MemoryCache: NewMemoryCache(0)}
+	fallback := NewMemoryCache(0)
+	return NewFallbackCache(primary, fallback), primary, fallback
+}
+
+// recoverPrimary brings the primary back and skips the retry interval, then makes
+// a call so the cache notices and resyncs
+func recoverPrimary(f *FallbackCache, primary *flakyCache) {
+	primary.down.Store(false)
+	f.retryAt.Store(0)
+	f.Get(context.Background(), "probe")
+}
+
+// waitFor polls cond since resync runs in the background
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func missing(c Cache, key string) bool {
+	_, err := c.Get(context.Background(), key)
+	return errors.Is(err, ErrCacheMiss)
+}
+
+func TestFallbackCacheServesFromMemoryWhilePrimaryIsDown(t *testing.T) {
+	ctx := context.Background()
+	f, primary, fallback := newTestFallbackCache()
+
+	f.Set(ctx, "fs:v1:a", "primary", 0)
+	if v, _ := primary.MemoryCache.Get(ctx, "fs:v1:a"); v != "primary" {
+		t.Fatalf("Set while up did not reach the primary")
+	}
+
+	primary.down.Store(true)
+	if _, err := f.Get(ctx, "fs:v1:a"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get while down error = %v, want ErrCacheMiss from the fallback", err)
+	}
+	if err := f.Set(ctx, "fs:v1:b", "memory", 0); err != nil {
+		t.Errorf("Set while down error = %v", err)
+	}
+	if v, err := f.Get(ctx, "fs:v1:b"); err != nil || v != "memory" {
+		t.Errorf("Get while down = %q, %v, want memory", v, err)
+	}
+	if v, _ := fallback.Get(ctx, "fs:v1:b"); v != "memory" {
+		t.Errorf("Set while down did not reach the fallback")
+	}
+	if n, err := f.Incr(ctx, "fs:v1:n", 0); err != nil || n != 1 {
+		t.Errorf("Incr while down = %d, %v, want 1", n, err)
+	}
+
+	// The primary is skipped for the retry interval, even once it is back
+	primary.down.Store(false)
+	if _, err := f.Get(ctx, "fs:v1:a"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get within the retry interval error = %v, want the fallback", err)
+	}
+}
+
+func TestFallbackCacheReplaysMissedInvalidations(t *testing.T) {
+	ctx := context.Background()
+	f, primary, fallback := newTestFallbackCache()
+
+	for _, key := range []string{"fs:v1:key", "fs:v1:user:1:a", "fs:v1:user:2:a", "fs:v1:version"} {
+		f.Set(ctx, key, "1", 0)
+	}
+
+	primary.down.Store(true)
+	f.Delete(ctx, "fs:v1:key")
+	f.DeleteByPrefix(ctx, "fs:v1:user:1:")
+	f.Incr(ctx, "fs:v1:version", 0)
+	f.Set(ctx, "fs:v1:outage", "x", 0)
+
+	// Entries written before the outage are still in the primary
+	if missing(primary.MemoryCache, "fs:v1:key") {
+		t.Fatalf("primary changed while it was down")
+	}
+
+	recoverPrimary(f, primary)
+	waitFor(t, "missed invalidations to replay", func() bool {
+		return missing(primary.MemoryCache, "fs:v1:key") &&
+			missing(primary.MemoryCache, "fs:v1:user:1:a") &&
+			missing(primary.MemoryCache, "fs:v1:version")
+	})
+	if missing(primary.MemoryCache, "fs:v1:user:2:a") {
+		t.Errorf("resync removed an entry that was not invalidated")
+	}
+	waitFor(t, "the fallback to be emptied", func() bool {
+		return missing(fallback, "fs:v1:outage") && missing(fallback, "fs:v1:version")
+	})
+
+	// Nothing is replayed twice
+	f.Set(ctx, "fs:v1:key", "2", 0)
+	primary.down.Store(true)
+	f.Get(ctx, "fs:v1:key")
+	recoverPrimary(f, primary)
+	time.Sleep(20 * time.Millisecond)
+	if v, err := primary.MemoryCache.Get(ctx, "fs:v1:key"); err != nil || v != "2" {
+		t.Errorf("Get(key) after a second recovery = %q, %v, want 2", v, err)
+	}
+}
+
+func TestFallbackCacheDropsNamespaceWhenTooManyMissed(t *testing.T) {
+	ctx := context.Background()
+	f, primary, _ := newTestFallbackCache()
+
+	f.Set(ctx, "fs:v1:kept", "1", 0)
+	primary.MemoryCache.Set(ctx, "other:key", "1", 0)
+
+	primary.down.Store(true)
+	for i := 0; i <= maxMissedKeys; i++ {
+		f.Delete(ctx, fmt.Sprintf("fs:v1:gone:%d", i))
+	}
+	f.mu.Lock()
+	all := f.missedAll
+	f.mu.Unlock()
+	if !all {
+		t.Fatalf("missedAll = false after %d missed keys", maxMissedKeys+1)
+	}
+
+	recoverPrimary(f, primary)
+	waitFor(t, "the namespace to be dropped", func() bool {
+		return missing(primary.MemoryCache, "fs:v1:kept")
+	})
+	if missing(primary.MemoryCache, "other:key") {
+		t.Errorf("resync removed a key outside the cache namespace")
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksums holds the hex encoded digests of a stored object
+type Checksums struct {
+	SHA256 string
+	MD5    string
+	CRC32C string
+}
+
+// ComputeChecksums reads r to the end and returns its digests and length
+func ComputeChecksums(r io.Reader) (Checksums, int64, error) {
+	sha := sha256.New()
+	md := md5.New()
+	crc := crc32.New(crc32cTable)
+
+	n, err := io.Copy(io.MultiWriter(sha, md, crc), r)
+	if err != nil {
+		return Checksums{}, n, err
+	}
+
+	return Checksums{
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+		MD5:    hex.EncodeToString(md.Sum(nil)),
+		CRC32C: hex.EncodeToString(crc.Sum(nil)),
+	}, n, nil
+}
+
+// SHA256Base64 returns the SHA-256 digest in the base64 form used by S3 and the Digest header
+func (c Checksums) SHA256Base64() string {
+	return hexToBase64(c.SHA256)
+}
+
+// MD5Base64 returns the MD5 digest in the base64 form expected by Content-MD5
+func (c Checksums) MD5Base64() string {
+	return hexToBase64(c.MD5)
+}
+
+// CRC32CBase64 returns the CRC32C checksum in the base64 form used by S3
+func (c Checksums) CRC32CBase64() string {
+	return hexToBase64(c.CRC32C)
+}
+
+func hexToBase64(s string) string {
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
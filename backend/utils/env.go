@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PublicBaseURL returns the scheme and host clients reach the API on, from
+// PUBLIC_BASE_URL. Links handed to other people are built from it rather
+// than from request headers, which the client controls.
+func PublicBaseURL() string {
+	if v := os.Getenv("PUBLIC_BASE_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return "http://localhost:" + port
+}
+
+// EnvDuration reads a time.Duration from the environment, falling back to def
+func EnvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("Warning: invalid %s %q, using %s", key, v, def)
+	}
+	return def
+}
+
+// EnvInt64 reads an int64 from the environment, falling back to def
+func EnvInt64(key string, def int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+		log.Printf("Warning: invalid %s %q, using %d", key, v, def)
+	}
+	return def
+}
+
+// EnvBool reads a bool from the environment, falling back to def
+func EnvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+		log.Printf("Warning: invalid %s %q, using %t", key, v, def)
+	}
+	return def
+}
+
+// EnvSize reads a byte size such as 10MB from the environment, falling back to def
+func EnvSize(key string, def int64) int64 {
+	if v := os.Getenv(key); v != "" {
+		if n, err := ParseSize(v); err == nil {
+			return n
+		}
+		log.Printf("Warning: invalid %s %q, using %d", key, v, def)
+	}
+	return def
+}
@@ -0,0 +1,371 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// eventBuffer is how many events a subscriber may fall behind before it is
+// dropped; the client then reconnects and catches up from the history
+const eventBuffer = 256
+
+// ChangeEvent is one change pushed to a user's live event stream. IDs look
+// like Redis stream IDs ("<ms>-<seq>") and increase per user.
+type ChangeEvent struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Subscription delivers a user's events until its context is done. Events
+// is closed when the subscriber falls too far behind. Missed is set when
+// the events after the requested ID are no longer retained, so the client
+// must reload instead of resuming.
+type Subscription struct {
+	Events <-chan ChangeEvent
+	Missed bool
+}
+
+// EventBus publishes change events to every subscriber of a user, on any
+// instance, and keeps a short history so clients can resume after a
+// disconnect
+type EventBus interface {
+	Publish(ctx context.Context, userID uint, eventType string, data interface{}) error
+	// Subscribe replays the retained events after lastID (none when empty)
+	// and then delivers new events as they are published
+	Subscribe(ctx context.Context, userID uint, lastID string) *Subscription
+}
+
+// NewEventBus fans events out through Redis pub/sub when a client is
+// configured and within the process otherwise. History is bounded by
+// EVENT_HISTORY events per user (default 1000); in Redis it also expires
+// EVENT_RETENTION (default 24h) after the last event.
+func NewEventBus(client *redis.Client) EventBus {
+	size := int(EnvInt64("EVENT_HISTORY", 1000))
+	if client == nil {
+		return NewLocalEventBus(size)
+	}
+	return NewRedisEventBus(client, size, EnvDuration("EVENT_RETENTION", 24*time.Hour))
+}
+
+// parseEventID splits an event ID into its millisecond and sequence parts
+func parseEventID(id string) (uint64, uint64, bool) {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	m, err1 := strconv.ParseUint(ms, 10, 64)
+	s, err2 := strconv.ParseUint(seq, 10, 64)
+	return m, s, err1 == nil && err2 == nil
+}
+
+// eventIDAfter reports whether event ID a comes after b
+func eventIDAfter(a, b string) bool {
+	am, as, _ := parseEventID(a)
+	bm, bs, _ := parseEventID(b)
+	return am > bm || (am == bm && as > bs)
+}
+
+// eventHub fans events out to the subscribers in this process
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[uint]map[chan ChangeEvent]struct{}
+}
+
+func (h *eventHub) add(userID uint) chan ChangeEvent {
+	ch := make(chan ChangeEvent, eventBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[uint]map[chan ChangeEvent]struct{})
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan ChangeEvent]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	return ch
+}
+
+// remove closes ch unless it was already dropped; callers hold the lock
+func (h *eventHub) remove(userID uint, ch chan ChangeEvent) {
+	if _, ok := h.subs[userID][ch]; !ok {
+		return
+	}
+	delete(h.subs[userID], ch)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+	close(ch)
+}
+
+// deliver never blocks: a subscriber whose buffer is full is dropped
+func (h *eventHub) deliver(userID uint, event ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- event:
+		default:
+			h.remove(userID, ch)
+		}
+	}
+}
+
+// subscribe registers for live events before reading the history, so no
+// event falls between the two; live events already replayed are skipped
+func (h *eventHub) subscribe(ctx context.Context, userID uint, lastID string, history func(lastID string) ([]ChangeEvent, bool)) *Subscription {
+	live := h.add(userID)
+
+	var backlog []ChangeEvent
+	missed := false
+	if lastID != "" {
+		var ok bool
+		if backlog, ok = history(lastID); !ok {
+			backlog, missed, lastID = nil, true, ""
+		}
+	}
+
+	out := make(chan ChangeEvent, eventBuffer)
+	go func() {
+		defer close(out)
+		defer func() {
+			h.mu.Lock()
+			h.remove(userID, live)
+			h.mu.Unlock()
+		}()
+
+		seen := lastID
+		for _, event := range backlog {
+			select {
+			case out <- event:
+				seen = event.ID
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case event, ok := <-live:
+				if !ok {
+					return
+				}
+				if seen != "" && !eventIDAfter(event.ID, seen) {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return &Subscription{Events: out, Missed: missed}
+}
+
+// LocalEventBus keeps events in process memory, for single-node deployments
+type LocalEventBus struct {
+	hub     eventHub
+	mu      sync.Mutex
+	size    int
+	history map[uint][]ChangeEvent
+	lastMs  int64
+	seq     int64
+}
+
+func NewLocalEventBus(size int) *LocalEventBus {
+	return &LocalEventBus{size: size, history: make(map[uint][]ChangeEvent)}
+}
+
+func (b *LocalEventBus) Publish(ctx context.Context, userID uint, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= b.lastMs {
+		ms, b.seq = b.lastMs, b.seq+1
+	} else {
+		b.seq = 0
+	}
+	b.lastMs = ms
+	event := ChangeEvent{ID: fmt.Sprintf("%d-%d", ms, b.seq), Type: eventType, Data: encoded}
+	events := append(b.history[userID], event)
+	if len(events) > b.size {
+		events = events[len(events)-b.size:]
+	}
+	b.history[userID] = events
+	b.mu.Unlock()
+
+	b.hub.deliver(userID, event)
+	return nil
+}
+
+func (b *LocalEventBus) Subscribe(ctx context.Context, userID uint, lastID string) *Subscription {
+	return b.hub.subscribe(ctx, userID, lastID, func(lastID string) ([]ChangeEvent, bool) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		events := b.history[userID]
+		for i, event := range events {
+			if event.ID == lastID {
+				return append([]ChangeEvent(nil), events[i+1:]...), true
+			}
+		}
+		return nil, false
+	})
+}
+
+// publishEventScript appends the event to the user's capped stream and
+// publishes it in one step, so subscribers see events in stream order
+var publishEventScript = redis.NewScript(`
+local id = redis.call("XADD", KEYS[1], "MAXLEN", "~", ARGV[1], "*", "type", ARGV[2], "data", ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+redis.call("PUBLISH", KEYS[1], id .. "\n" .. ARGV[2] .. "\n" .. ARGV[3])
+return id`)
+
+const eventChannelPrefix = "events:user:"
+
+// RedisEventBus keeps each user's history in a Redis stream and fans
+// events out to every instance through a single pattern subscription
+type RedisEventBus struct {
+	client    *redis.Client
+	hub       eventHub
+	size      int
+	retention time.Duration
+}
+
+func NewRedisEventBus(client *redis.Client, size int, retention time.Duration) *RedisEventBus {
+	b := &RedisEventBus{client: client, size: size, retention: retention}
+	go b.listen()
+	return b
+}
+
+// listen delivers published events to local subscribers; go-redis
+// resubscribes on its own after a lost connection
+func (b *RedisEventBus) listen() {
+	pubsub := b.client.PSubscribe(context.Background(), eventChannelPrefix+"*")
+	for msg := range pubsub.Channel() {
+		userID, err := strconv.ParseUint(strings.TrimPrefix(msg.Channel, eventChannelPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		parts := strings.SplitN(msg.Payload, "\n", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		b.hub.deliver(uint(userID), ChangeEvent{ID: parts[0], Type: parts[1], Data: json.RawMessage(parts[2])})
+	}
+}
+
+func (b *RedisEventBus) Publish(ctx context.Context, userID uint, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	key := eventChannelPrefix + strconv.FormatUint(uint64(userID), 10)
+	return publishEventScript.Run(ctx, b.client, []string{key}, b.size, eventType, string(encoded), b.retention.Milliseconds()).Err()
+}
+
+func (b *RedisEventBus) Subscribe(ctx context.Context, userID uint, lastID string) *Subscription {
+	return b.hub.subscribe(ctx, userID, lastID, func(lastID string) ([]ChangeEvent, bool) {
+		if _, _, ok := parseEventID(lastID); !ok {
+			return nil, false
+		}
+		key := eventChannelPrefix + strconv.FormatUint(uint64(userID), 10)
+		entries, err := b.client.XRangeN(ctx, key, lastID, "+", int64(b.size)+1).Result()
+		if err != nil {
+			log.Printf("Error reading event history for user %d: %v", userID, err)
+			return nil, false
+		}
+		// The requested event must still be retained, or some after it are gone
+		if len(entries) == 0 || entries[0].ID != lastID {
+			return nil, false
+		}
+		events := make([]ChangeEvent, 0, len(entries)-1)
+		for _, entry := range entries[1:] {
+			eventType, _ := entry.Values["type"].(string)
+			data, _ := entry.Values["data"].(string)
+			events = append(events, ChangeEvent{ID: entry.ID, Type: eventType, Data: json.RawMessage(data)})
+		}
+		return events, true
+	})
+}
+
+// streamTicketTTL is how long a ticket from NewStreamTicket can be redeemed,
+// and how long it stays valid after the last stream using it closed
+const streamTicketTTL = time.Minute
+
+// ErrInvalidTicket is returned for unknown or expired stream tickets
+var ErrInvalidTicket = errors.New("invalid stream ticket")
+
+type streamTicket struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// NewStreamTicket issues a short-lived ticket that opens the event stream in place of the bearer token, since the browser's
+// EventSource cannot send headers and URLs end up in logs
+func NewStreamTicket(ctx context.Context, cache Cache, userID uint, email string) (string, time.Duration, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", 0, err
+	}
+	ticket := hex.EncodeToString(b)
+	value, err := json.Marshal(streamTicket{UserID: userID, Email: email})
+	if err != nil {
+		return "", 0, err
+	}
+	if err := cache.Set(ctx, "stream-ticket:"+ticket, string(value), streamTicketTTL); err != nil {
+		return "", 0, err
+	}
+	return ticket, streamTicketTTL, nil
+}
+
+// RedeemStreamTicket returns the user a ticket was issued to. EventSource
+// reconnects with the same URL, so the ticket is kept valid while ctx (the
+// stream it opened) lasts and for streamTicketTTL after, which lets the
+// browser resume with Last-Event-ID.
+func RedeemStreamTicket(ctx context.Context, cache Cache, ticket string) (uint, string, error) {
+	key := "stream-ticket:" + ticket
+	value, err := cache.Get(ctx, key)
+	if err != nil {
+		return 0, "", ErrInvalidTicket
+	}
+
+	var t streamTicket
+	if err := json.Unmarshal([]byte(value), &t); err != nil || t.UserID == 0 {
+		return 0, "", ErrInvalidTicket
+	}
+
+	go func() {
+		refresh := time.NewTicker(streamTicketTTL / 3)
+		defer refresh.Stop()
+		for {
+			select {
+			case <-refresh.C:
+			case <-ctx.Done():
+				// Start the grace period for a reconnect
+				cache.Set(context.Background(), key, value, streamTicketTTL)
+				return
+			}
+			cache.Set(context.Background(), key, value, streamTicketTTL)
+		}
+	}()
+	return t.UserID, t.Email, nil
+}
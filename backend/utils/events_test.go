@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestEventIDAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"1700000000001-0", "1700000000000-0", true},
+		{"1700000000000-1", "1700000000000-0", true},
+		{"1700000000000-10", "1700000000000-9", true},
+		{"1700000000000-0", "1700000000000-0", false},
+		{"1700000000000-0", "1700000000000-1", false},
+		{"1700000000000-5", "1700000000001-0", false},
+		{"1700000000000-0", "", true},
+		{"1700000000000-0", "garbage", true},
+		{"", "1700000000000-0", false},
+	}
+
+	for _, tt := range tests {
+		if got := eventIDAfter(tt.a, tt.b); got != tt.want {
+			t.Errorf("eventIDAfter(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func jpegSegment(marker byte, payload string) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func pngChunk(typ, data string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ+data...)
+	return append(chunk, 0, 0, 0, 0) // CRC, not checked
+}
+
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := bytes.Join(chunks, nil)
+	file := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body)+4))
+	file = append(file, "WEBP"...)
+	return append(file, body...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	app0 := jpegSegment(0xE0, "JFIF\x00")
+	dqt := jpegSegment(0xDB, "tables")
+	scan := append(jpegSegment(0xDA, "scan"), 0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD9)
+
+	ihdr := pngChunk("IHDR", "0123456789abc")
+	idat := pngChunk("IDAT", "pixels")
+	iend := pngChunk("IEND", "")
+
+	vp8 := webpChunk("VP8 ", []byte("frame"))
+
+	tests := []struct {
+		name     string
+		mimeType string
+		in       []byte
+		want     []byte
+	}{
+		{
+			"jpeg",
+			"image/jpeg",
+			concat(soi, app0, jpegSegment(0xE1, "Exif\x00\x00GPS"), jpegSegment(0xED, "IPTC"), jpegSegment(0xFE, "comment"), dqt, scan),
+			concat(soi, app0, dqt, scan),
+		},
+		{
+			"jpeg without metadata",
+			"image/jpeg",
+			concat(soi, app0, dqt, scan),
+			concat(soi, app0, dqt, scan),
+		},
+		{
+			"png",
+			"image/png",
+			concat(pngSignature, ihdr, pngChunk("tEXt", "Author\x00me"), pngChunk("eXIf", "MM"), pngChunk("iTXt", "x"), pngChunk("zTXt", "y"), idat, iend),
+			concat(pngSignature, ihdr, idat, iend),
+		},
+		{
+			"webp",
+			"image/webp",
+			webpFile(webpChunk("VP8X", []byte{0x10 | 0x08 | 0x04, 0, 0, 0, 1, 0, 0, 1, 0, 0}), webpChunk("EXIF", []byte("odd")), webpChunk("XMP ", []byte("<x/>")), vp8),
+			webpFile(webpChunk("VP8X", []byte{0x10, 0, 0, 0, 1, 0, 0, 1, 0, 0}), vp8),
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := StripImageMetadata(tt.in, tt.mimeType)
+		if err != nil {
+			t.Errorf("%s: StripImageMetadata error: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: StripImageMetadata =\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripImageMetadataErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		mimeType string
+		in       []byte
+		want     error
+	}{
+		{"unsupported format", "image/gif", []byte("GIF89a"), ErrCannotStripMetadata},
+		{"not a jpeg", "image/jpeg", []byte("not an image"), errMalformedImage},
+		{"jpeg without scan", "image/jpeg", concat([]byte{0xFF, 0xD8}, jpegSegment(0xE0, "JFIF\x00")), errMalformedImage},
+		{"truncated jpeg segment", "image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x10, 0x00, 0x00}, errMalformedImage},
+		{"not a png", "image/png", []byte("\x89PNX\r\n\x1a\n"), errMalformedImage},
+		{"truncated png chunk", "image/png", concat(pngSignature, []byte{0, 0, 1, 0, 'I', 'D', 'A', 'T', 0, 0, 0, 0}), errMalformedImage},
+		{"not a webp", "image/webp", []byte("RIFF\x04\x00\x00\x00WAVE"), errMalformedImage},
+		{"truncated webp chunk", "image/webp", webpFile([]byte("VP8 \xff\x00\x00\x00")), errMalformedImage},
+	}
+
+	for _, tt := range tests {
+		if _, err := StripImageMetadata(tt.in, tt.mimeType); err != tt.want {
+			t.Errorf("%s: StripImageMetadata error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTTL parses a time to live such as 3600 (seconds), 90m, 36h, 7d or 2w
+func ParseTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return 0, errors.New("empty duration")
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return checkTTL(time.Duration(n) * time.Second)
+	}
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil {
+				return 0, errors.New("invalid duration " + s)
+			}
+			return checkTTL(time.Duration(n * float64(unit)))
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("invalid duration " + s)
+	}
+	return checkTTL(d)
+}
+
+func checkTTL(d time.Duration) (time.Duration, error) {
+	if d <= 0 {
+		return 0, errors.New("duration must be positive")
+	}
+	return d, nil
+}
+
+// ResolveExpiry turns an absolute expires_at (RFC 3339) or a relative
+// expires_in (see ParseTTL) into an expiry time. set is false when neither was
+// given. "never" for either clears the expiry.
+func ResolveExpiry(expiresAt, expiresIn string, now time.Time) (expiry *time.Time, set bool, err error) {
+	expiresAt, expiresIn = strings.TrimSpace(expiresAt), strings.TrimSpace(expiresIn)
+	switch {
+	case expiresAt == "" && expiresIn == "":
+		return nil, false, nil
+	case expiresAt != "" && expiresIn != "":
+		return nil, true, errors.New("give either expires_at or expires_in, not both")
+	case strings.EqualFold(expiresAt, "never") || strings.EqualFold(expiresIn, "never"):
+		return nil, true, nil
+	}
+
+	var t time.Time
+	if expiresAt != "" {
+		t, err = time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, true, errors.New("expires_at must be an RFC 3339 timestamp")
+		}
+	} else {
+		ttl, err := ParseTTL(expiresIn)
+		if err != nil {
+			return nil, true, err
+		}
+		t = now.Add(ttl)
+	}
+
+	if !t.After(now) {
+		return nil, true, errors.New("expiry must be in the future")
+	}
+	if max := EnvDuration("FILE_MAX_TTL", 0); max > 0 && t.After(now.Add(max)) {
+		return nil, true, errors.New("expiry is further out than FILE_MAX_TTL allows")
+	}
+	return &t, true, nil
+}
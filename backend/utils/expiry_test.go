@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"3600", time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"36h", 36 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{" 7D ", 7 * 24 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"", 0, true},
+		{"0", 0, true},
+		{"-1h", 0, true},
+		{"0d", 0, true},
+		{"xd", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTTL(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTTL(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestResolveExpiry(t *testing.T) {
+	t.Setenv("FILE_MAX_TTL", "720h")
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		expiresAt string
+		expiresIn string
+		want      *time.Time
+		wantSet   bool
+		wantErr   bool
+	}{
+		{"", "", nil, false, false},
+		{"  ", "", nil, false, false},
+		{"", "7d", at(7 * 24 * time.Hour), true, false},
+		{"2026-05-02T12:00:00Z", "", at(24 * time.Hour), true, false},
+		{"never", "", nil, true, false},
+		{"", "NEVER", nil, true, false},
+		{"2026-05-02T12:00:00Z", "1d", nil, true, true},
+		{"tomorrow", "", nil, true, true},
+		{"", "soon", nil, true, true},
+		{"2026-04-30T12:00:00Z", "", nil, true, true},
+		{"2026-05-01T12:00:00Z", "", nil, true, true},
+		{"", "31d", nil, true, true},
+	}
+
+	for _, tt := range tests {
+		got, set, err := ResolveExpiry(tt.expiresAt, tt.expiresIn, now)
+		if (err != nil) != tt.wantErr || set != tt.wantSet {
+			t.Errorf("ResolveExpiry(%q, %q) set = %v, error = %v, want set %v, wantErr %v",
+				tt.expiresAt, tt.expiresIn, set, err, tt.wantSet, tt.wantErr)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || (got != nil && !got.Equal(*tt.want)) {
+			t.Errorf("ResolveExpiry(%q, %q) = %v, want %v", tt.expiresAt, tt.expiresIn, got, tt.want)
+		}
+	}
+}
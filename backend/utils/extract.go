@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+)
+
+// MaxExtractedText caps the amount of text indexed per file
+const MaxExtractedText = 1 << 20
+
+// ErrNotIndexable is returned for file types ExtractText does not understand
+var ErrNotIndexable = errors.New("file type is not indexable")
+
+// Office Open XML MIME types
+const (
+	mimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimePPTX = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+// Indexable reports whether ExtractText can pull text out of a file
+func Indexable(mimeType, filename string) bool {
+	return extractKind(mimeType, filename) != ""
+}
+
+func extractKind(mimeType, filename string) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	switch mediaType {
+	case "text/html", "application/xhtml+xml":
+		return "html"
+	case mimeDOCX, mimeXLSX, mimePPTX:
+		return "ooxml"
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".html", ".htm":
+		return "html"
+	case ".docx", ".xlsx", ".pptx":
+		return "ooxml"
+	}
+
+	switch PreviewKind(mimeType, filename) {
+	case PreviewText, PreviewMarkdown, PreviewCSV:
+		return "text"
+	case PreviewPDF:
+		return "pdf"
+	}
+	return ""
+}
+
+// ExtractText returns the plain text content of a text, markdown, HTML, PDF
+// or Office Open XML (docx/xlsx/pptx) document, truncated to MaxExtractedText.
+func ExtractText(data []byte, mimeType, filename string) (string, error) {
+	var text string
+	var err error
+
+	switch extractKind(mimeType, filename) {
+	case "text":
+		text, _, err = DecodeText(data, mimeType)
+	case "html":
+		text, err = extractHTML(data, mimeType)
+	case "pdf":
+		text, err = extractPDF(data)
+	case "ooxml":
+		text, err = extractOOXML(data)
+	default:
+		return "", ErrNotIndexable
+	}
+	if err != nil {
+		return "", err
+	}
+
+	text = strings.ToValidUTF8(text, "")
+	text = strings.ReplaceAll(text, "\x00", "")
+	if len(text) > MaxExtractedText {
+		text = strings.ToValidUTF8(text[:MaxExtractedText], "")
+	}
+	return text, nil
+}
+
+func extractHTML(data []byte, contentType string) (string, error) {
+	decoded, _, err := DecodeText(data, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(decoded))
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return b.String(), nil
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); isInvisibleTag(string(name)) {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); isInvisibleTag(string(name)) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				if text := strings.TrimSpace(string(tokenizer.Text())); text != "" {
+					b.WriteString(text)
+					b.WriteByte('\n')
+				}
+			}
+		}
+	}
+}
+
+func isInvisibleTag(name string) bool {
+	return name == "script" || name == "style" || name == "noscript" || name == "template"
+}
+
+func extractPDF(data []byte) (text string, err error) {
+	// The PDF reader panics on malformed input
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("invalid PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", err
+	}
+	out, err := io.ReadAll(io.LimitReader(plain, MaxExtractedText))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// extractOOXML collects the text runs of a docx, xlsx or pptx package
+// maxOOXMLPart caps the decompressed bytes read from one document part, so a
+// small archive that inflates to little text cannot keep the decoder busy
+const maxOOXMLPart = 64 * MaxExtractedText
+
+func extractOOXML(data []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var parts []*zip.File
+	for _, f := range archive.File {
+		name := f.Name
+		switch {
+		case name == "word/document.xml",
+			strings.HasPrefix(name, "word/header"), strings.HasPrefix(name, "word/footer"),
+			name == "xl/sharedStrings.xml",
+			strings.HasPrefix(name, "ppt/slides/slide") && strings.HasSuffix(name, ".xml"):
+			parts = append(parts, f)
+		}
+	}
+	// Keep slides and sections in document order
+	sort.Slice(parts, func(i, j int) bool { return naturalLess(parts[i].Name, parts[j].Name) })
+
+	var b strings.Builder
+	for _, part := range parts {
+		if b.Len() >= MaxExtractedText {
+			break
+		}
+		rc, err := part.Open()
+		if err != nil {
+			return "", err
+		}
+		limited := &io.LimitedReader{R: rc, N: maxOOXMLPart}
+		err = collectXMLText(limited, &b)
+		rc.Close()
+		// A part cut off at the read limit still keeps the text before it
+		if err != nil && limited.N > 0 {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// collectXMLText appends the character data of every <t> element, breaking
+// lines at paragraph and shared string boundaries. It stops once b holds
+// MaxExtractedText bytes.
+func collectXMLText(r io.Reader, b *strings.Builder) error {
+	decoder := xml.NewDecoder(r)
+	inText := false
+	for b.Len() < MaxExtractedText {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			inText = t.Name.Local == "t"
+		case xml.EndElement:
+			inText = false
+			if t.Name.Local == "p" || t.Name.Local == "si" {
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+	return nil
+}
+
+// naturalLess orders names so that slide2.xml sorts before slide10.xml
+func naturalLess(a, b string) bool {
+	prefixA, numA := splitTrailingNumber(a)
+	prefixB, numB := splitTrailingNumber(b)
+	if prefixA == prefixB {
+		return numA < numB
+	}
+	return a < b
+}
+
+func splitTrailingNumber(name string) (string, int) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	i := len(base)
+	for i > 0 && base[i-1] >= '0' && base[i-1] <= '9' {
+		i--
+	}
+	n, _ := strconv.Atoi(base[i:])
+	return base[:i], n
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func docx(t *testing.T, document string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(document))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractOOXML(t *testing.T) {
+	paragraph := "<w:p><w:r><w:t>" + strings.Repeat("lorem ipsum ", 50) + "</w:t></w:r></w:p>"
+	large := `<w:document><w:body>` + strings.Repeat(paragraph, 4*MaxExtractedText/len(paragraph)+100) + `</w:body></w:document>`
+
+	tests := []struct {
+		name     string
+		document string
+		want     string
+		minLen   int
+		wantErr  bool
+	}{
+		{
+			name:     "paragraphs",
+			document: `<w:document><w:body><w:p><w:r><w:t>Hello</w:t></w:r><w:r><w:t> world</w:t></w:r></w:p><w:p><w:r><w:t>Bye</w:t></w:r></w:p></w:body></w:document>`,
+			want:     "Hello world\nBye\n",
+		},
+		{
+			name:     "larger than the text limit",
+			document: large,
+			minLen:   MaxExtractedText,
+		},
+		{
+			name:     "malformed",
+			document: `<w:document><w:body><w:p>`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ExtractText(docx(t, tt.document), mimeDOCX, "doc.docx")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ExtractText error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%s: ExtractText = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(got) < tt.minLen || len(got) > MaxExtractedText {
+			t.Errorf("%s: ExtractText returned %d bytes, want between %d and %d", tt.name, len(got), tt.minLen, MaxExtractedText)
+		}
+	}
+}
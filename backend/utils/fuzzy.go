@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// trigramSearch is set once pg_trgm and unaccent are installed and indexed
+var trigramSearch bool
+
+// EnableTrigramSearch installs the Postgres extensions, the immutable unaccent
+// wrapper and the trigram indexes used by fuzzy name and tag search. On other
+// databases, or when the extensions cannot be created, fuzzy search falls
+// back to scoring names in Go.
+func EnableTrigramSearch(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE EXTENSION IF NOT EXISTS unaccent`,
+		// unaccent() is only STABLE; indexes need an IMMUTABLE wrapper with a fixed dictionary
+		`CREATE OR REPLACE FUNCTION f_unaccent(text) RETURNS text AS
+			$$ SELECT public.unaccent('public.unaccent', $1) $$
+			LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT`,
+		`CREATE INDEX IF NOT EXISTS idx_files_original_name_trgm
+			ON files USING gin (lower(f_unaccent(original_name)) gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_tags_name_trgm
+			ON tags USING gin (lower(f_unaccent(name)) gin_trgm_ops)`,
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	trigramSearch = true
+	return nil
+}
+
+// TrigramSearchEnabled reports whether fuzzy search can run in the database
+func TrigramSearchEnabled() bool {
+	return trigramSearch
+}
+
+var foldTransformer = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// FoldText lowercases s and strips accents so "Résumé" matches "resume"
+func FoldText(s string) string {
+	folded, _, err := transform.String(foldTransformer, s)
+	if err != nil {
+		folded = s
+	}
+	return strings.ToLower(folded)
+}
+
+// trigrams returns the pg_trgm style trigram set of s: every alphanumeric
+// word padded with two leading spaces and one trailing space.
+func trigrams(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+	words := strings.FieldsFunc(FoldText(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range a {
+		if _, ok := b[t]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// Similarity scores how closely name matches query between 0 and 1, as the
+// better of pg_trgm's similarity over the whole name and an approximation of
+// its word_similarity: the share of the query's trigrams found in one word.
+func Similarity(query, name string) float64 {
+	q := trigrams(query)
+	best := jaccard(q, trigrams(name))
+	if len(q) == 0 {
+		return best
+	}
+
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		shared := 0
+		for t := range trigrams(word) {
+			if _, ok := q[t]; ok {
+				shared++
+			}
+		}
+		if score := float64(shared) / float64(len(q)); score > best {
+			best = score
+		}
+	}
+	return best
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		query string
+		name  string
+		want  float64
+	}{
+		{"report", "report", 1},
+		{"Report", "REPORT.pdf", 1},
+		{"resume", "Résumé.docx", 1},
+		{"word", "two words", 0.8},
+		{"two words", "two words", 1},
+		{"xyz", "abc", 0},
+		{"", "report", 0},
+		{"report", "", 0},
+		{"!!", "report", 0},
+	}
+
+	for _, tt := range tests {
+		if got := Similarity(tt.query, tt.name); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Similarity(%q, %q) = %v, want %v", tt.query, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarityRanksCloserNames(t *testing.T) {
+	near := Similarity("quartely", "quarterly-report.xlsx")
+	far := Similarity("quartely", "holiday-photos.zip")
+	if near <= far {
+		t.Errorf("Similarity ranks a typo of the name (%v) below an unrelated name (%v)", near, far)
+	}
+}
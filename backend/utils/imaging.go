@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxImagePixels bounds the decoded size of images we are willing to process
+const MaxImagePixels = 50_000_000
+
+// ErrUnsupportedImage is returned for formats the image pipeline cannot decode
+var ErrUnsupportedImage = errors.New("unsupported image format")
+
+// Thumbnailable reports whether files of the given MIME type get thumbnails
+func Thumbnailable(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// DecodeImage decodes a JPEG, PNG, GIF or WebP image after checking its dimensions
+func DecodeImage(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, "", ErrUnsupportedImage
+		}
+		return nil, "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return nil, "", fmt.Errorf("image dimensions %dx%d out of range", cfg.Width, cfg.Height)
+	}
+
+	return image.Decode(bytes.NewReader(data))
+}
+
+// Thumbnail scales img down so that neither side exceeds maxSide, keeping its
+// aspect ratio. Images that already fit are returned unchanged.
+func Thumbnail(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+
+	if w >= h {
+		w, h = maxSide, max(1, h*maxSide/w)
+	} else {
+		w, h = max(1, w*maxSide/h), maxSide
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Rect, img, b, draw.Src, nil)
+	return dst
+}
+
+// EncodeThumbnail writes img as PNG when the source format may carry
+// transparency and as JPEG otherwise, returning the content type used.
+func EncodeThumbnail(w io.Writer, img image.Image, sourceFormat string) (string, error) {
+	switch sourceFormat {
+	case "png", "gif", "webp":
+		return "image/png", png.Encode(w, img)
+	default:
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+}
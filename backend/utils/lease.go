@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLeaseHeld is returned when another instance holds the lease
+var ErrLeaseHeld = errors.New("lease is held by another instance")
+
+// ErrLeaseLost is returned when renewing a lease that expired or was taken over
+var ErrLeaseLost = errors.New("lease lost")
+
+// ErrLeaseUnavailable is returned when the lease store cannot be reached, so
+// whether the lease is free is unknown
+var ErrLeaseUnavailable = errors.New("lease store is unreachable")
+
+// acquireScript takes the lease if it is free and bumps the fencing counter
+// in the same step, so every holder gets a strictly larger token.
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+
+// seedFenceScript raises the fencing counter to at least ARGV[1]
+var seedFenceScript = redis.NewScript(`
+if tonumber(redis.call("GET", KEYS[1]) or "0") < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 0`)
+
+// renewScript extends the lease only while we still own it
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes the lease only while we still own it
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lease is a time-limited lock held by one instance at a time. Token is a
+// fencing token: it grows with every acquisition, so storage writes guarded
+// by it reject a holder that lost the lease without noticing.
+type Lease struct {
+	Name  string
+	Token int64
+	owner string
+	ttl   time.Duration
+	store LeaseStore
+}
+
+// LeaseStore hands out leases. RedisLeases coordinates every instance;
+// LocalLeases only this process, for single-node deployments.
+type LeaseStore interface {
+	// Acquire takes the named lease for ttl, or returns ErrLeaseHeld
+	Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error)
+	// SeedFence makes later tokens of the named lease larger than min, for
+	// when the counter was lost and fell behind tokens already recorded
+	SeedFence(ctx context.Context, name string, min int64) error
+	renew(ctx context.Context, l *Lease) error
+	release(ctx context.Context, l *Lease) error
+}
+
+// NewLeaseStore uses Redis when a client is configured and process-local
+// leases otherwise
+func NewLeaseStore(client *redis.Client) LeaseStore {
+	if client == nil {
+		return NewLocalLeases()
+	}
+	return NewRedisLeases(client)
+}
+
+func leaseKey(name string) string {
+	return "lease:" + name
+}
+
+func fenceKey(name string) string {
+	return leaseKey(name) + ":fence"
+}
+
+// isConnectionError reports whether err means Redis could not be reached,
+// as opposed to Redis answering with an error
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, redis.ErrClosed) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func newLeaseOwner() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// RedisLeases keeps leases in Redis so they are shared by all instances
+type RedisLeases struct {
+	client *redis.Client
+}
+
+func NewRedisLeases(client *redis.Client) *RedisLeases {
+	return &RedisLeases{client: client}
+}
+
+func (r *RedisLeases) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	owner, err := newLeaseOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := acquireScript.Run(ctx, r.client, []string{leaseKey(name), fenceKey(name)},
+		owner, ttl.Milliseconds()).Int64()
+	if isConnectionError(err) {
+		return nil, fmt.Errorf("%w: %v", ErrLeaseUnavailable, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token == 0 {
+		return nil, ErrLeaseHeld
+	}
+	return &Lease{Name: name, Token: token, owner: owner, ttl: ttl, store: r}, nil
+}
+
+func (r *RedisLeases) SeedFence(ctx context.Context, name string, min int64) error {
+	return seedFenceScript.Run(ctx, r.client, []string{fenceKey(name)}, min).Err()
+}
+
+func (r *RedisLeases) renew(ctx context.Context, l *Lease) error {
+	ok, err := renewScript.Run(ctx, r.client, []string{leaseKey(l.Name)}, l.owner, l.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (r *RedisLeases) release(ctx context.Context, l *Lease) error {
+	return releaseScript.Run(ctx, r.client, []string{leaseKey(l.Name)}, l.owner).Err()
+}
+
+type localLease struct {
+	owner   string
+	expires time.Time
+}
+
+// LocalLeases keeps leases in process memory
+type LocalLeases struct {
+	mu     sync.Mutex
+	held   map[string]localLease
+	fences map[string]int64
+}
+
+func NewLocalLeases() *LocalLeases {
+	return &LocalLeases{held: make(map[string]localLease), fences: make(map[string]int64)}
+}
+
+func (m *LocalLeases) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	owner, err := newLeaseOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if held, ok := m.held[name]; ok && time.Now().Before(held.expires) {
+		return nil, ErrLeaseHeld
+	}
+	m.held[name] = localLease{owner: owner, expires: time.Now().Add(ttl)}
+	m.fences[name]++
+	return &Lease{Name: name, Token: m.fences[name], owner: owner, ttl: ttl, store: m}, nil
+}
+
+func (m *LocalLeases) SeedFence(ctx context.Context, name string, min int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fences[name] < min {
+		m.fences[name] = min
+	}
+	return nil
+}
+
+func (m *LocalLeases) renew(ctx context.Context, l *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held, ok := m.held[l.Name]
+	if !ok || held.owner != l.owner || time.Now().After(held.expires) {
+		return ErrLeaseLost
+	}
+	m.held[l.Name] = localLease{owner: l.owner, expires: time.Now().Add(l.ttl)}
+	return nil
+}
+
+func (m *LocalLeases) release(ctx context.Context, l *Lease) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if held, ok := m.held[l.Name]; ok && held.owner == l.owner {
+		delete(m.held, l.Name)
+	}
+	return nil
+}
+
+// Renew extends the lease by its ttl
+func (l *Lease) Renew(ctx context.Context) error {
+	return l.store.renew(ctx, l)
+}
+
+// Release gives the lease up early; releasing a lost lease is a no-op
+func (l *Lease) Release(ctx context.Context) error {
+	return l.store.release(ctx, l)
+}
+
+// Hold renews the lease in the background every third of its ttl. The
+// returned context is cancelled when the lease is lost or can no longer be
+// confirmed; calling cancel stops renewing and releases the lease.
+func (l *Lease) Hold(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	go func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				l.Release(context.Background())
+				return
+			case <-ticker.C:
+				renewCtx, done := context.WithTimeout(ctx, l.ttl/3)
+				err := l.Renew(renewCtx)
+				done()
+				if err != nil {
+					log.Printf("Lost lease %s (token %d): %v", l.Name, l.Token, err)
+					cancel()
+				}
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testLeaseTTL = 50 * time.Millisecond
+
+// leaseStores returns each LeaseStore with a function that lets a lease's
+// ttl pass
+func leaseStores(t *testing.T) map[string]struct {
+	store  LeaseStore
+	expire func()
+} {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]struct {
+		store  LeaseStore
+		expire func()
+	}{
+		"local": {NewLocalLeases(), func() { time.Sleep(testLeaseTTL + 10*time.Millisecond) }},
+		"redis": {NewRedisLeases(client), func() { mr.FastForward(testLeaseTTL + time.Millisecond) }},
+	}
+}
+
+func TestLeaseStore(t *testing.T) {
+	ctx := context.Background()
+	for name, tt := range leaseStores(t) {
+		first, err := tt.store.Acquire(ctx, "job", testLeaseTTL)
+		if err != nil || first.Token != 1 {
+			t.Fatalf("%s: first Acquire = %+v, %v, want token 1", name, first, err)
+		}
+		if _, err := tt.store.Acquire(ctx, "job", testLeaseTTL); !errors.Is(err, ErrLeaseHeld) {
+			t.Errorf("%s: Acquire of a held lease error = %v, want ErrLeaseHeld", name, err)
+		}
+		if other, err := tt.store.Acquire(ctx, "other", testLeaseTTL); err != nil || other.Token != 1 {
+			t.Errorf("%s: Acquire of another lease = %+v, %v, want token 1", name, other, err)
+		}
+		if err := first.Renew(ctx); err != nil {
+			t.Errorf("%s: Renew error = %v", name, err)
+		}
+
+		// Once the ttl passes, the next holder gets a newer token
+		tt.expire()
+		second, err := tt.store.Acquire(ctx, "job", testLeaseTTL)
+		if err != nil || second.Token != 2 {
+			t.Fatalf("%s: Acquire after expiry = %+v, %v, want token 2", name, second, err)
+		}
+		if err := first.Renew(ctx); !errors.Is(err, ErrLeaseLost) {
+			t.Errorf("%s: Renew of a lost lease error = %v, want ErrLeaseLost", name, err)
+		}
+
+		// Releasing a lost lease leaves the new holder alone
+		first.Release(ctx)
+		if _, err := tt.store.Acquire(ctx, "job", testLeaseTTL); !errors.Is(err, ErrLeaseHeld) {
+			t.Errorf("%s: Acquire after releasing a lost lease error = %v, want ErrLeaseHeld", name, err)
+		}
+		second.Release(ctx)
+		third, err := tt.store.Acquire(ctx, "job", testLeaseTTL)
+		if err != nil || third.Token != 3 {
+			t.Errorf("%s: Acquire after Release = %+v, %v, want token 3", name, third, err)
+		}
+	}
+}
+
+func TestLeaseStoreSeedFence(t *testing.T) {
+	ctx := context.Background()
+	for name, tt := range leaseStores(t) {
+		tests := []struct {
+			seed int64
+			want int64
+		}{
+			{0, 1},
+			{10, 11},
+			{5, 12}, // seeding never lowers the counter
+		}
+		for _, step := range tests {
+			if err := tt.store.SeedFence(ctx, "scheduler", step.seed); err != nil {
+				t.Fatalf("%s: SeedFence(%d) error = %v", name, step.seed, err)
+			}
+			lease, err := tt.store.Acquire(ctx, "scheduler", testLeaseTTL)
+			if err != nil || lease.Token != step.want {
+				t.Errorf("%s: Acquire after SeedFence(%d) = %+v, %v, want token %d", name, step.seed, lease, err, step.want)
+				continue
+			}
+			lease.Release(ctx)
+		}
+	}
+}
+
+func TestRedisLeasesUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	mr.Close()
+
+	_, err := NewRedisLeases(client).Acquire(context.Background(), "job", testLeaseTTL)
+	if !errors.Is(err, ErrLeaseUnavailable) {
+		t.Errorf("Acquire with Redis down error = %v, want ErrLeaseUnavailable", err)
+	}
+}
+
+func TestLeaseHold(t *testing.T) {
+	leases := NewLocalLeases()
+	lease, err := leases.Acquire(context.Background(), "job", testLeaseTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renewals keep the lease alive well past its ttl
+	ctx, release := lease.Hold(context.Background())
+	time.Sleep(3 * testLeaseTTL)
+	if ctx.Err() != nil {
+		t.Fatalf("Hold context ended while the lease was renewed: %v", ctx.Err())
+	}
+	if _, err := leases.Acquire(context.Background(), "job", testLeaseTTL); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("Acquire of a held lease error = %v, want ErrLeaseHeld", err)
+	}
+
+	// Stopping releases it for the next holder
+	release()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := leases.Acquire(context.Background(), "job", testLeaseTTL); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lease was not released after Hold was cancelled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Mailer delivers plain text email. Implementations other than SMTP, such as
+// a provider's HTTP API, plug in through SetMailer in the jobs package.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer picks the mailer from MAIL_BACKEND: "smtp" sends through
+// SMTP_HOST and "log" only logs messages. It defaults to smtp when SMTP_HOST
+// is set and to log otherwise.
+func NewMailer() Mailer {
+	switch backend := os.Getenv("MAIL_BACKEND"); backend {
+	case "smtp":
+		return NewSMTPMailer()
+	case "log":
+		return LogMailer{}
+	case "":
+	default:
+		log.Printf("Warning: unknown MAIL_BACKEND %q", backend)
+	}
+	if os.Getenv("SMTP_HOST") != "" {
+		return NewSMTPMailer()
+	}
+	return LogMailer{}
+}
+
+// LogMailer logs messages instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s not sent (no mail server configured): %s", to, subject)
+	return nil
+}
+
+// SMTPMailer sends through the SMTP server configured with SMTP_HOST,
+// SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+type SMTPMailer struct {
+	host, port, from string
+	auth             smtp.Auth
+}
+
+func NewSMTPMailer() *SMTPMailer {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@" + host
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return &SMTPMailer{host: host, port: port, from: from, auth: auth}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	// Header values must not contain line breaks
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		clean.Replace(m.from), clean.Replace(to), clean.Replace(subject), time.Now().Format(time.RFC1123Z), body)
+
+	return smtp.SendMail(m.host+":"+m.port, m.auth, m.from, []string{to}, []byte(msg))
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	key     string
+	value   string
+	expires time.Time
+}
+
+// MemoryCache is a process-local Cache that evicts the least recently used
+// entry once it holds maxEntries. It suits single-node deployments, tests
+// and serving while Redis is down.
+type MemoryCache struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	order      *list.List
+	maxEntries int
+}
+
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	return &MemoryCache{items: make(map[string]*list.Element), order: list.New(), maxEntries: maxEntries}
+}
+
+// Get retrieves a string value, or ErrCacheMiss
+func (m *MemoryCache) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.lookup(key)
+	if entry == nil {
+		return "", ErrCacheMiss
+	}
+	return entry.value, nil
+}
+
+// Set stores a string value; a zero ttl keeps it until evicted or deleted
+func (m *MemoryCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(key, value, expiry(ttl))
+	return nil
+}
+
+func (m *MemoryCache) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	return nil
+}
+
+func (m *MemoryCache) DeleteByPrefix(ctx context.Context, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, el := range m.items {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+// Incr adds one to a counter and returns the new value. A new counter
+// expires after ttl; zero keeps it until evicted or deleted.
+func (m *MemoryCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.lookup(key)
+	if entry == nil {
+		m.put(key, "1", expiry(ttl))
+		return 1, nil
+	}
+	n, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	entry.value = strconv.FormatInt(n+1, 10)
+	return n + 1, nil
+}
+
+// lookup returns a live entry and marks it recently used; callers hold the lock
+func (m *MemoryCache) lookup(key string) *memoryEntry {
+	el, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.remove(el)
+		return nil
+	}
+	m.order.MoveToFront(el)
+	return entry
+}
+
+// put stores an entry, evicting from the back of the list when full;
+// callers hold the lock
+func (m *MemoryCache) put(key, value string, expires time.Time) {
+	if el, ok := m.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.value, entry.expires = value, expires
+		m.order.MoveToFront(el)
+		return
+	}
+	for m.order.Len() >= m.maxEntries {
+		m.remove(m.order.Back())
+	}
+	m.items[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+}
+
+func (m *MemoryCache) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.items, el.Value.(*memoryEntry).key)
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
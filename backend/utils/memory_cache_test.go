@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testCacheTTL = 50 * time.Millisecond
+
+// caches returns each Cache backend with a function that lets testCacheTTL
+// pass
+func caches(t *testing.T) map[string]struct {
+	cache  Cache
+	expire func()
+} {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]struct {
+		cache  Cache
+		expire func()
+	}{
+		"memory": {NewMemoryCache(0), func() { time.Sleep(testCacheTTL + 10*time.Millisecond) }},
+		"redis":  {NewRedisCache(client), func() { mr.FastForward(testCacheTTL + time.Millisecond) }},
+	}
+}
+
+func TestCacheGetSet(t *testing.T) {
+	ctx := context.Background()
+	for name, tt := range caches(t) {
+		if _, err := tt.cache.Get(ctx, "missing"); !errors.Is(err, ErrCacheMiss) {
+			t.Errorf("%s: Get(missing) error = %v, want ErrCacheMiss", name, err)
+		}
+
+		tt.cache.Set(ctx, "kept", "a", 0)
+		tt.cache.Set(ctx, "short", "b", testCacheTTL)
+		if v, err := tt.cache.Get(ctx, "short"); err != nil || v != "b" {
+			t.Errorf("%s: Get(short) = %q, %v, want b", name, v, err)
+		}
+
+		tt.expire()
+		if _, err := tt.cache.Get(ctx, "short"); !errors.Is(err, ErrCacheMiss) {
+			t.Errorf("%s: Get(short) after ttl error = %v, want ErrCacheMiss", name, err)
+		}
+		if v, err := tt.cache.Get(ctx, "kept"); err != nil || v != "a" {
+			t.Errorf("%s: Get(kept) after ttl = %q, %v, want a", name, v, err)
+		}
+
+		tt.cache.Delete(ctx, "kept")
+		if _, err := tt.cache.Get(ctx, "kept"); !errors.Is(err, ErrCacheMiss) {
+			t.Errorf("%s: Get(kept) after Delete error = %v, want ErrCacheMiss", name, err)
+		}
+	}
+}
+
+func TestCacheDeleteByPrefix(t *testing.T) {
+	ctx := context.Background()
+	for name, tt := range caches(t) {
+		for _, key := range []string{"fs:v1:user:1:a", "fs:v1:user:1:b", "fs:v1:user:12:a", "fs:v1:user:2:a"} {
+			tt.cache.Set(ctx, key, "x", 0)
+		}
+		// Glob characters in the prefix are matched literally
+		tt.cache.Set(ctx, "fs:v1:user:*:a", "x", 0)
+
+		tt.cache.DeleteByPrefix(ctx, "fs:v1:user:1:")
+
+		want := map[string]bool{
+			"fs:v1:user:1:a":  false,
+			"fs:v1:user:1:b":  false,
+			"fs:v1:user:12:a": true,
+			"fs:v1:user:2:a":  true,
+			"fs:v1:user:*:a":  true,
+		}
+		for key, kept := range want {
+			_, err := tt.cache.Get(ctx, key)
+			if got := err == nil; got != kept {
+				t.Errorf("%s: %s kept = %v, want %v", name, key, got, kept)
+			}
+		}
+
+		tt.cache.DeleteByPrefix(ctx, "fs:v1:user:*")
+		if _, err := tt.cache.Get(ctx, "fs:v1:user:2:a"); err != nil {
+			t.Errorf("%s: DeleteByPrefix(user:*) removed user:2: %v", name, err)
+		}
+		if _, err := tt.cache.Get(ctx, "fs:v1:user:*:a"); !errors.Is(err, ErrCacheMiss) {
+			t.Errorf("%s: DeleteByPrefix(user:*) kept user:*: %v", name, err)
+		}
+	}
+}
+
+func TestCacheIncr(t *testing.T) {
+	ctx := context.Background()
+	for name, tt := range caches(t) {
+		for want := int64(1); want <= 3; want++ {
+			if n, err := tt.cache.Incr(ctx, "hits", testCacheTTL); err != nil || n != want {
+				t.Fatalf("%s: Incr = %d, %v, want %d", name, n, err, want)
+			}
+		}
+		if v, err := tt.cache.Get(ctx, "hits"); err != nil || v != "3" {
+			t.Errorf("%s: Get(hits) = %q, %v, want 3", name, v, err)
+		}
+
+		// The window runs from the first hit; later hits don't extend it
+		tt.expire()
+		if n, err := tt.cache.Incr(ctx, "hits", testCacheTTL); err != nil || n != 1 {
+			t.Errorf("%s: Incr after ttl = %d, %v, want 1", name, n, err)
+		}
+
+		tt.cache.Set(ctx, "name", "not a number", 0)
+		if _, err := tt.cache.Incr(ctx, "name", 0); err == nil {
+			t.Errorf("%s: Incr on a non-numeric value succeeded", name)
+		}
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(2)
+
+	cache.Set(ctx, "a", "1", 0)
+	cache.Set(ctx, "b", "2", 0)
+	cache.Get(ctx, "a")
+	cache.Set(ctx, "c", "3", 0)
+
+	if _, err := cache.Get(ctx, "b"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get(b) error = %v, want b evicted", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := cache.Get(ctx, key); err != nil {
+			t.Errorf("Get(%s) error = %v, want kept", key, err)
+		}
+	}
+
+	// Overwriting an existing key does not evict anything
+	cache.Set(ctx, "a", "4", 0)
+	if v, err := cache.Get(ctx, "a"); err != nil || v != "4" {
+		t.Errorf("Get(a) = %q, %v, want 4", v, err)
+	}
+	if _, err := cache.Get(ctx, "c"); err != nil {
+		t.Errorf("Get(c) error = %v, want kept after overwrite", err)
+	}
+}
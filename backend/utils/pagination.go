@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"filesharing/models"
+
+	"gorm.io/gorm"
+)
+
+// Page size bounds for list and search endpoints
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// fileSortColumns maps the sort names accepted by the API to columns on files
+var fileSortColumns = map[string]string{
+	"name":     "files.original_name",
+	"size":     "files.size",
+	"created":  "files.created_at",
+	"modified": "files.updated_at",
+}
+
+// PageRequest selects the order and window of a file listing
+type PageRequest struct {
+	Sort   string
+	Order  string
+	Limit  int
+	Cursor string
+}
+
+// pageCursor is the keyset position after the last row of a page
+type pageCursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+// Normalize validates the request and fills in defaults
+func (p *PageRequest) Normalize() error {
+	if p.Sort == "" {
+		p.Sort = "created"
+	}
+	if _, ok := fileSortColumns[p.Sort]; !ok {
+		return &QueryError{Term: p.Sort, Msg: "unknown sort field"}
+	}
+
+	if p.Order == "" {
+		p.Order = "desc"
+		if p.Sort == "name" {
+			p.Order = "asc"
+		}
+	}
+	if p.Order != "asc" && p.Order != "desc" {
+		return &QueryError{Term: p.Order, Msg: "order must be asc or desc"}
+	}
+
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		p.Limit = MaxPageSize
+	}
+	return nil
+}
+
+// Apply orders db, skips to the cursor and fetches one row more than the page
+// so the caller can tell whether another page follows.
+func (p PageRequest) Apply(db *gorm.DB) (*gorm.DB, error) {
+	column := fileSortColumns[p.Sort]
+	cmp := "<"
+	if p.Order == "asc" {
+		cmp = ">"
+	}
+
+	if p.Cursor != "" {
+		value, id, err := p.decodeCursor()
+		if err != nil {
+			return nil, err
+		}
+		db = db.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND files.id %s ?))", column, cmp, column, cmp), value, value, id)
+	}
+
+	return db.Order(column + " " + p.Order).Order("files.id " + p.Order).Limit(p.Limit + 1), nil
+}
+
+// Trim drops the extra row fetched by Apply and returns the cursor of the next page
+func (p PageRequest) Trim(files []models.File) ([]models.File, string) {
+	if len(files) <= p.Limit {
+		return files, ""
+	}
+	files = files[:p.Limit]
+	last := files[len(files)-1]
+
+	var value interface{}
+	switch p.Sort {
+	case "name":
+		value = last.OriginalName
+	case "size":
+		value = last.Size
+	case "created":
+		value = last.CreatedAt
+	case "modified":
+		value = last.UpdatedAt
+	}
+
+	raw, _ := json.Marshal(value)
+	cursor, _ := json.Marshal(pageCursor{Value: raw, ID: last.ID})
+	return files, base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+func (p PageRequest) decodeCursor() (interface{}, uint, error) {
+	invalid := &QueryError{Term: p.Cursor, Msg: "invalid cursor"}
+
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, 0, invalid
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, 0, invalid
+	}
+
+	// Decode the sort value back into its column's Go type
+	switch p.Sort {
+	case "name":
+		var s string
+		if err := json.Unmarshal(cursor.Value, &s); err != nil {
+			return nil, 0, invalid
+		}
+		return s, cursor.ID, nil
+	case "size":
+		var n int64
+		if err := json.Unmarshal(cursor.Value, &n); err != nil {
+			return nil, 0, invalid
+		}
+		return n, cursor.ID, nil
+	default:
+		var t time.Time
+		if err := json.Unmarshal(cursor.Value, &t); err != nil {
+			return nil, 0, invalid
+		}
+		return t, cursor.ID, nil
+	}
+}
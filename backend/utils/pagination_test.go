@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"filesharing/models"
+)
+
+func TestPageRequestNormalize(t *testing.T) {
+	tests := []struct {
+		in      PageRequest
+		want    PageRequest
+		wantErr bool
+	}{
+		{PageRequest{}, PageRequest{Sort: "created", Order: "desc", Limit: DefaultPageSize}, false},
+		{PageRequest{Sort: "name"}, PageRequest{Sort: "name", Order: "asc", Limit: DefaultPageSize}, false},
+		{PageRequest{Sort: "size", Order: "asc", Limit: 10}, PageRequest{Sort: "size", Order: "asc", Limit: 10}, false},
+		{PageRequest{Limit: MaxPageSize + 1}, PageRequest{Sort: "created", Order: "desc", Limit: MaxPageSize}, false},
+		{PageRequest{Sort: "owner"}, PageRequest{}, true},
+		{PageRequest{Order: "up"}, PageRequest{}, true},
+	}
+
+	for _, tt := range tests {
+		got := tt.in
+		err := got.Normalize()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Normalize(%+v) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("Normalize(%+v) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPageCursorRoundTrip(t *testing.T) {
+	created := time.Date(2026, 3, 4, 5, 6, 7, 890, time.UTC)
+	file := models.File{OriginalName: "report.pdf", Size: 4096}
+	file.ID = 42
+	file.CreatedAt = created
+	file.UpdatedAt = created.Add(time.Hour)
+
+	tests := []struct {
+		sort string
+		want interface{}
+	}{
+		{"name", "report.pdf"},
+		{"size", int64(4096)},
+		{"created", created},
+		{"modified", created.Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		p := PageRequest{Sort: tt.sort, Limit: 1}
+		page, cursor := p.Trim([]models.File{file, {}})
+		if len(page) != 1 || cursor == "" {
+			t.Errorf("Trim(%s) = %d files, cursor %q", tt.sort, len(page), cursor)
+			continue
+		}
+
+		p.Cursor = cursor
+		value, id, err := p.decodeCursor()
+		if err != nil {
+			t.Errorf("decodeCursor(%s) error: %v", tt.sort, err)
+			continue
+		}
+		if id != file.ID {
+			t.Errorf("decodeCursor(%s) id = %d, want %d", tt.sort, id, file.ID)
+		}
+		if wantTime, ok := tt.want.(time.Time); ok {
+			if got, ok := value.(time.Time); !ok || !got.Equal(wantTime) {
+				t.Errorf("decodeCursor(%s) value = %v, want %v", tt.sort, value, wantTime)
+			}
+		} else if value != tt.want {
+			t.Errorf("decodeCursor(%s) value = %#v, want %#v", tt.sort, value, tt.want)
+		}
+	}
+}
+
+func TestPageTrimLastPage(t *testing.T) {
+	p := PageRequest{Sort: "name", Limit: 2}
+	page, cursor := p.Trim(make([]models.File, 2))
+	if len(page) != 2 || cursor != "" {
+		t.Errorf("Trim of a full last page = %d files, cursor %q", len(page), cursor)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	tests := []struct {
+		sort   string
+		cursor string
+	}{
+		{"name", "not base64!"},
+		{"name", "bm90IGpzb24"},               // "not json"
+		{"size", "eyJ2IjoiYWJjIiwiaWQiOjF9"},  // {"v":"abc","id":1}
+		{"created", "eyJ2IjoxMjMsImlkIjoxfQ"}, // {"v":123,"id":1}
+	}
+
+	for _, tt := range tests {
+		p := PageRequest{Sort: tt.sort, Cursor: tt.cursor}
+		if _, _, err := p.decodeCursor(); err == nil {
+			t.Errorf("decodeCursor(%s, %q) succeeded, want error", tt.sort, tt.cursor)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
+)
+
+// Preview kinds returned by PreviewKind
+const (
+	PreviewText     = "text"
+	PreviewMarkdown = "markdown"
+	PreviewCSV      = "csv"
+	PreviewPDF      = "pdf"
+)
+
+var (
+	markdown       = goldmark.New(goldmark.WithExtensions(extension.GFM))
+	markdownPolicy = bluemonday.UGCPolicy()
+)
+
+// PreviewKind picks how a file is previewed from its MIME type, falling back
+// to the extension for uploads sent as application/octet-stream.
+func PreviewKind(mimeType, filename string) string {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	switch mediaType {
+	case "text/markdown", "text/x-markdown":
+		return PreviewMarkdown
+	case "text/csv", "application/csv":
+		return PreviewCSV
+	case "application/pdf":
+		return PreviewPDF
+	case "application/json", "application/xml", "application/x-yaml", "application/yaml":
+		return PreviewText
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return PreviewText
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown":
+		return PreviewMarkdown
+	case ".csv":
+		return PreviewCSV
+	case ".pdf":
+		return PreviewPDF
+	case ".txt", ".log", ".json", ".xml", ".yaml", ".yml", ".go", ".js", ".ts", ".py":
+		return PreviewText
+	}
+	return ""
+}
+
+// DecodeText converts data to UTF-8, detecting its encoding from BOMs,
+// the declared content type and the bytes themselves.
+func DecodeText(data []byte, contentType string) (string, string, error) {
+	enc, name, _ := charset.DetermineEncoding(data, contentType)
+	out, _, err := transform.Bytes(enc.NewDecoder(), data)
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimPrefix(string(out), "\uFEFF"), name, nil
+}
+
+// TextExcerpt returns at most maxRunes of s with control characters other
+// than newlines and tabs removed, and whether it was truncated.
+func TextExcerpt(s string, maxRunes int) (string, bool) {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if n >= maxRunes {
+			return b.String(), true
+		}
+		if r == utf8.RuneError || (unicode.IsControl(r) && r != '\n' && r != '\t') {
+			continue
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String(), false
+}
+
+// RenderMarkdown renders markdown source to HTML that is safe to embed
+func RenderMarkdown(src string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+	return markdownPolicy.Sanitize(buf.String()), nil
+}
+
+// CSVPreview returns the header and up to maxRows data rows of a CSV document,
+// and whether more rows follow.
+func CSVPreview(r io.Reader, maxRows int) ([]string, [][]string, bool, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return []string{}, [][]string{}, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	rows := [][]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return header, rows, false, nil
+		}
+		if err != nil {
+			// A row cut off by the preview size cap is not an error worth reporting
+			return header, rows, true, nil
+		}
+		if len(rows) == maxRows {
+			return header, rows, true, nil
+		}
+		rows = append(rows, record)
+	}
+}
+
+// PDFInfo holds the page count and document information of a PDF
+type PDFInfo struct {
+	Pages int               `json:"pages"`
+	Info  map[string]string `json:"info"`
+}
+
+// ReadPDFInfo extracts the page count and Info dictionary from a PDF document
+func ReadPDFInfo(data []byte) (info *PDFInfo, err error) {
+	// The PDF reader panics on malformed input
+	defer func() {
+		if r := recover(); r != nil {
+			info, err = nil, fmt.Errorf("invalid PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	info = &PDFInfo{Pages: reader.NumPage(), Info: map[string]string{}}
+	dict := reader.Trailer().Key("Info")
+	for _, key := range dict.Keys() {
+		if text := strings.TrimSpace(dict.Key(key).Text()); text != "" {
+			info.Info[key] = text
+		}
+	}
+	return info, nil
+}
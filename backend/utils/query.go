@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QueryError reports a malformed structured search query
+type QueryError struct {
+	Term string
+	Msg  string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("invalid search term %q: %s", e.Term, e.Msg)
+}
+
+// FileQuery is a parsed structured search over a user's files, for example
+//
+//	name:report type:pdf size:>10MB modified:<2026-01-01 in:/projects
+//	tag:finance meta.project:apollo
+//
+// Bare words match the file name, quoted values may contain spaces and a
+// leading "-" negates a term.
+type FileQuery struct {
+	conditions []queryCondition
+}
+
+type queryCondition struct {
+	sql  string
+	args []interface{}
+}
+
+// queryFilter turns the value of a field:value term into a condition on files
+type queryFilter func(value string) (string, []interface{}, error)
+
+var queryFilters = map[string]queryFilter{
+	"name":     nameFilter,
+	"type":     typeFilter,
+	"size":     sizeFilter,
+	"modified": timeFilter("files.updated_at"),
+	"created":  timeFilter("files.created_at"),
+	"in":       folderFilter,
+	"tag":      tagFilter,
+}
+
+// metadataFieldPrefix selects a custom metadata key, as in meta.project:apollo
+const metadataFieldPrefix = "meta."
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidMetadataKey reports whether key may be used in a file's custom metadata
+func ValidMetadataKey(key string) bool {
+	return metadataKeyPattern.MatchString(key)
+}
+
+// ParseFileQuery parses a structured search query
+func ParseFileQuery(input string) (*FileQuery, error) {
+	terms, err := tokenizeQuery(input)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &FileQuery{}
+	for _, term := range terms {
+		raw := term
+		negate := false
+		if len(term) > 1 && term[0] == '-' {
+			negate, term = true, term[1:]
+		}
+
+		filter, value := nameFilter, term
+		if field, v, ok := strings.Cut(term, ":"); ok && !strings.HasPrefix(field, `"`) {
+			f, known := queryFilters[strings.ToLower(field)]
+			if key, ok := strings.CutPrefix(field, metadataFieldPrefix); ok && ValidMetadataKey(key) {
+				f, known = metadataFilter(key), true
+			}
+			if !known {
+				return nil, &QueryError{Term: raw, Msg: "unknown filter " + field}
+			}
+			filter, value = f, v
+		}
+
+		value = strings.Trim(value, `"`)
+		if value == "" {
+			return nil, &QueryError{Term: raw, Msg: "missing value"}
+		}
+
+		sql, args, err := filter(value)
+		if err != nil {
+			return nil, &QueryError{Term: raw, Msg: err.Error()}
+		}
+		if negate {
+			sql = "NOT (" + sql + ")"
+		}
+		q.conditions = append(q.conditions, queryCondition{sql: sql, args: args})
+	}
+
+	return q, nil
+}
+
+// Apply adds the query's conditions to db, which must select from files
+func (q *FileQuery) Apply(db *gorm.DB) *gorm.DB {
+	for _, cond := range q.conditions {
+		db = db.Where(cond.sql, cond.args...)
+	}
+	return db
+}
+
+// tokenizeQuery splits on whitespace outside double quotes
+func tokenizeQuery(input string) ([]string, error) {
+	var terms []string
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range input {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, &QueryError{Term: current.String(), Msg: "unterminated quote"}
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms, nil
+}
+
+// EscapeLike escapes LIKE wildcards so s matches literally
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func nameFilter(value string) (string, []interface{}, error) {
+	return "files.original_name ILIKE ?", []interface{}{"%" + EscapeLike(value) + "%"}, nil
+}
+
+// typeFilter accepts a MIME type (image/png), a MIME family (image) or an extension (pdf)
+func typeFilter(value string) (string, []interface{}, error) {
+	value = strings.ToLower(value)
+	switch {
+	case strings.HasSuffix(value, "/*"):
+		return "files.mime_type LIKE ?", []interface{}{EscapeLike(strings.TrimSuffix(value, "*")) + "%"}, nil
+	case strings.Contains(value, "/"):
+		return "files.mime_type = ?", []interface{}{value}, nil
+	}
+
+	switch value {
+	case "image", "video", "audio", "text", "font":
+		return "files.mime_type LIKE ?", []interface{}{value + "/%"}, nil
+	}
+
+	ext := "." + strings.TrimPrefix(value, ".")
+	byExt := "%" + EscapeLike(ext)
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		mimeType = strings.Split(mimeType, ";")[0]
+		return "(files.mime_type = ? OR files.original_name ILIKE ?)", []interface{}{mimeType, byExt}, nil
+	}
+	return "files.original_name ILIKE ?", []interface{}{byExt}, nil
+}
+
+// splitComparison separates a leading comparison operator from its operand
+func splitComparison(value string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			return op, value[len(op):]
+		}
+	}
+	return "=", value
+}
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1 << 10, "kb": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40,
+}
+
+// ParseSize parses sizes such as 512, 10KB or 1.5GB into bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i == -1 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	unit, ok := sizeUnits[strings.TrimSpace(s[i:])]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	bytes := n * unit
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// sizeFilter accepts size:>10MB, size:<=1GB, size:2KB..4KB
+func sizeFilter(value string) (string, []interface{}, error) {
+	if lo, hi, ok := strings.Cut(value, ".."); ok {
+		min, err := ParseSize(lo)
+		if err != nil {
+			return "", nil, err
+		}
+		max, err := ParseSize(hi)
+		if err != nil {
+			return "", nil, err
+		}
+		return "files.size BETWEEN ? AND ?", []interface{}{min, max}, nil
+	}
+
+	op, operand := splitComparison(value)
+	n, err := ParseSize(operand)
+	if err != nil {
+		return "", nil, err
+	}
+	return "files.size " + op + " ?", []interface{}{n}, nil
+}
+
+// parseQueryTime parses a date (whole day) or an RFC 3339 timestamp
+func parseQueryTime(s string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", s)
+}
+
+// timeFilter compares a timestamp column; dates cover the whole day, so
+// modified:>2026-01-01 means after that day and modified:2026-01-01 means on it.
+func timeFilter(column string) queryFilter {
+	return func(value string) (string, []interface{}, error) {
+		op, operand := splitComparison(value)
+		t, wholeDay, err := parseQueryTime(operand)
+		if err != nil {
+			return "", nil, err
+		}
+		if !wholeDay {
+			return column + " " + op + " ?", []interface{}{t}, nil
+		}
+
+		next := t.AddDate(0, 0, 1)
+		switch op {
+		case ">":
+			return column + " >= ?", []interface{}{next}, nil
+		case ">=":
+			return column + " >= ?", []interface{}{t}, nil
+		case "<":
+			return column + " < ?", []interface{}{t}, nil
+		case "<=":
+			return column + " < ?", []interface{}{next}, nil
+		default:
+			return column + " >= ? AND " + column + " < ?", []interface{}{t, next}, nil
+		}
+	}
+}
+
+// NormalizeFolderPath cleans a folder path into the /a/b form stored on folders
+func NormalizeFolderPath(p string) string {
+	return path.Clean("/" + strings.TrimSpace(p))
+}
+
+// folderFilter matches files in a folder or any of its subfolders; in:/ matches the root only
+func folderFilter(value string) (string, []interface{}, error) {
+	p := NormalizeFolderPath(value)
+	if p == "/" {
+		return "files.folder_id IS NULL", nil, nil
+	}
+	return `files.folder_id IN (SELECT id FROM folders WHERE deleted_at IS NULL AND (path = ? OR path LIKE ?))`,
+		[]interface{}{p, EscapeLike(p) + "/%"}, nil
+}
+
+// tagFilter matches files carrying a tag, ignoring case
+func tagFilter(value string) (string, []interface{}, error) {
+	return `files.id IN (SELECT file_tags.file_id FROM file_tags JOIN tags ON tags.id = file_tags.tag_id
+		WHERE tags.deleted_at IS NULL AND lower(tags.name) = lower(?))`, []interface{}{value}, nil
+}
+
+// metadataFilter matches a top-level custom metadata value by its text form;
+// meta.key:* matches any file that has the key.
+func metadataFilter(key string) queryFilter {
+	return func(value string) (string, []interface{}, error) {
+		if value == "*" {
+			return "files.custom_metadata ->> ? IS NOT NULL", []interface{}{key}, nil
+		}
+		return "files.custom_metadata ->> ? = ?", []interface{}{key, value}, nil
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFileQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []queryCondition
+	}{
+		{"", nil},
+		{"report", []queryCondition{{"files.original_name ILIKE ?", []interface{}{"%report%"}}}},
+		{`name:"q1 report"`, []queryCondition{{"files.original_name ILIKE ?", []interface{}{"%q1 report%"}}}},
+		{"name:50%_off", []queryCondition{{"files.original_name ILIKE ?", []interface{}{`%50\%\_off%`}}}},
+		{"-name:draft", []queryCondition{{"NOT (files.original_name ILIKE ?)", []interface{}{"%draft%"}}}},
+		{"type:image/png", []queryCondition{{"files.mime_type = ?", []interface{}{"image/png"}}}},
+		{"type:image", []queryCondition{{"files.mime_type LIKE ?", []interface{}{"image/%"}}}},
+		{"type:video/*", []queryCondition{{"files.mime_type LIKE ?", []interface{}{"video/%"}}}},
+		{"size:>10MB", []queryCondition{{"files.size > ?", []interface{}{int64(10 << 20)}}}},
+		{"size:1KB..2KB", []queryCondition{{"files.size BETWEEN ? AND ?", []interface{}{int64(1024), int64(2048)}}}},
+		{"in:/", []queryCondition{{"files.folder_id IS NULL", nil}}},
+		{"meta.project:*", []queryCondition{{"files.custom_metadata ->> ? IS NOT NULL", []interface{}{"project"}}}},
+		{"meta.project:apollo", []queryCondition{{"files.custom_metadata ->> ? = ?", []interface{}{"project", "apollo"}}}},
+		{"a  b", []queryCondition{
+			{"files.original_name ILIKE ?", []interface{}{"%a%"}},
+			{"files.original_name ILIKE ?", []interface{}{"%b%"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseFileQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseFileQuery(%q) error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(q.conditions, tt.want) {
+			t.Errorf("ParseFileQuery(%q) = %v, want %v", tt.input, q.conditions, tt.want)
+		}
+	}
+}
+
+func TestParseFileQueryErrors(t *testing.T) {
+	tests := []string{
+		`name:"unterminated`,
+		"color:red",
+		"name:",
+		`name:""`,
+		"size:>lots",
+		"size:1KB..",
+		"modified:yesterday",
+		"meta.bad key:x",
+	}
+
+	for _, input := range tests {
+		_, err := ParseFileQuery(input)
+		var qerr *QueryError
+		if !errors.As(err, &qerr) {
+			t.Errorf("ParseFileQuery(%q) error = %v, want *QueryError", input, err)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512b", 512, false},
+		{"10KB", 10 << 10, false},
+		{"10k", 10 << 10, false},
+		{"1.5GB", 3 << 29, false},
+		{" 2 mb ", 2 << 20, false},
+		{"1TB", 1 << 40, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"10XB", 0, true},
+		{"1.2.3", 0, true},
+		{"-5", 0, true},
+		{"99999999999TB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimit allows Rate requests per Period on average, with bursts of up
+// to Burst requests
+type RateLimit struct {
+	Rate   int64
+	Period time.Duration
+	Burst  int64
+}
+
+// interval is the time one request "costs" under the limit
+func (l RateLimit) interval() time.Duration {
+	return l.Period / time.Duration(l.Rate)
+}
+
+// RateResult describes the state of a key after a request
+type RateResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	ResetAfter time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, when denied
+}
+
+// RateLimiter applies the generic cell rate algorithm (GCRA), a token bucket
+// that stores only the theoretical arrival time of the next request per key
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit RateLimit) (RateResult, error)
+}
+
+// ParseRateLimit reads limits such as "60/1m" or "1000/24h". An empty value
+// returns def; "0" or "off" disables the limit, reported as ok == false.
+func ParseRateLimit(key string, def RateLimit) (RateLimit, bool) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, true
+	}
+	if v == "0" || v == "off" {
+		return RateLimit{}, false
+	}
+
+	count, period, found := strings.Cut(v, "/")
+	n, err := strconv.ParseInt(count, 10, 64)
+	d, derr := time.ParseDuration(period)
+	if !found || err != nil || derr != nil || n <= 0 || d <= 0 {
+		log.Printf("Warning: invalid %s %q, using %d/%s", key, v, def.Rate, def.Period)
+		return def, true
+	}
+	limit := RateLimit{Rate: n, Period: d, Burst: n}
+	if burst := EnvInt64(key+"_BURST", 0); burst > 0 {
+		limit.Burst = burst
+	}
+	return limit, true
+}
+
+// NewRateLimiter shares limits between instances through Redis, falling
+// back to per-instance limits while Redis is unreachable. Without a client
+// the limits are per instance.
+func NewRateLimiter(client *redis.Client) RateLimiter {
+	memory := NewMemoryRateLimiter()
+	if client == nil {
+		return memory
+	}
+	return &fallbackRateLimiter{primary: NewRedisRateLimiter(client), fallback: memory}
+}
+
+// gcra computes the outcome of one request from the stored arrival time
+// tat (zero when unknown). It returns the new tat to store when allowed.
+func gcra(now, tat time.Time, limit RateLimit) (RateResult, time.Time) {
+	interval := limit.interval()
+	tolerance := interval * time.Duration(limit.Burst)
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-tolerance)
+	result := RateResult{Limit: limit.Burst}
+	if now.Before(allowAt) {
+		result.RetryAfter = allowAt.Sub(now)
+		result.ResetAfter = tat.Sub(now)
+		return result, tat
+	}
+
+	result.Allowed = true
+	result.ResetAfter = newTat.Sub(now)
+	result.Remaining = int64((tolerance - result.ResetAfter) / interval)
+	return result, newTat
+}
+
+// RedisRateLimiter keeps the arrival times in Redis
+type RedisRateLimiter struct {
+	client *redis.Client
+}
+
+func NewRedisRateLimiter(client *redis.Client) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client}
+}
+
+// gcraScript runs the GCRA step atomically on the Redis clock, so instances
+// with skewed clocks still agree. It returns the allowed flag, remaining
+// requests, and the reset and retry delays in microseconds. The arrival
+// time is formatted with %d since Lua would otherwise store it in
+// scientific notation and lose precision.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or "0")
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - tolerance
+if now < allow_at then
+	return {0, 0, tat - now, allow_at - now}
+end
+
+redis.call("SET", KEYS[1], string.format("%d", new_tat), "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor((tolerance - (new_tat - now)) / interval), new_tat - now, 0}`)
+
+func (r *RedisRateLimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateResult, error) {
+	interval := limit.interval()
+	tolerance := interval * time.Duration(limit.Burst)
+	values, err := gcraScript.Run(ctx, r.client, []string{key}, interval.Microseconds(), tolerance.Microseconds()).Int64Slice()
+	if err != nil {
+		return RateResult{}, err
+	}
+	if len(values) != 4 {
+		return RateResult{}, fmt.Errorf("unexpected rate limit reply %v", values)
+	}
+	return RateResult{
+		Allowed:    values[0] == 1,
+		Limit:      limit.Burst,
+		Remaining:  values[1],
+		ResetAfter: time.Duration(values[2]) * time.Microsecond,
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+// MemoryRateLimiter keeps the arrival times in process memory
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	tats    map[string]time.Time
+	lastGC  time.Time
+	maxKeys int
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{tats: make(map[string]time.Time), maxKeys: 100000}
+}
+
+func (m *MemoryRateLimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateResult, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collect(now)
+
+	result, tat := gcra(now, m.tats[key], limit)
+	if result.Allowed {
+		m.tats[key] = tat
+	}
+	return result, nil
+}
+
+// collect drops keys whose bucket has refilled, at most once a minute or
+// when the map grows too large; callers hold the lock
+func (m *MemoryRateLimiter) collect(now time.Time) {
+	if now.Sub(m.lastGC) < time.Minute && len(m.tats) < m.maxKeys {
+		return
+	}
+	m.lastGC = now
+	for key, tat := range m.tats {
+		if tat.Before(now) {
+			delete(m.tats, key)
+		}
+	}
+}
+
+// fallbackRateLimiter uses per-instance limits while Redis fails
+type fallbackRateLimiter struct {
+	primary  RateLimiter
+	fallback RateLimiter
+	down     atomic.Bool
+	retryAt  atomic.Int64
+}
+
+func (f *fallbackRateLimiter) Allow(ctx context.Context, key string, limit RateLimit) (RateResult, error) {
+	if time.Now().UnixNano() >= f.retryAt.Load() {
+		result, err := f.primary.Allow(ctx, key, limit)
+		if err == nil {
+			if f.down.CompareAndSwap(true, false) {
+				log.Printf("Rate limiter is using Redis again")
+			}
+			return result, nil
+		}
+		f.retryAt.Store(time.Now().Add(fallbackRetryInterval).UnixNano())
+		if f.down.CompareAndSwap(false, true) {
+			log.Printf("Warning: Redis unavailable, rate limiting per instance: %v", err)
+		}
+	}
+	return f.fallback.Allow(ctx, key, limit)
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRA(t *testing.T) {
+	limit := RateLimit{Rate: 10, Period: 10 * time.Second, Burst: 3}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Each step runs against the arrival time stored by the step before
+	steps := []struct {
+		at         time.Duration
+		allowed    bool
+		remaining  int64
+		resetAfter time.Duration
+		retryAfter time.Duration
+	}{
+		{0, true, 2, 1 * time.Second, 0},
+		{0, true, 1, 2 * time.Second, 0},
+		{0, true, 0, 3 * time.Second, 0},
+		{0, false, 0, 3 * time.Second, 1 * time.Second},
+		{500 * time.Millisecond, false, 0, 2500 * time.Millisecond, 500 * time.Millisecond},
+		{1 * time.Second, true, 0, 3 * time.Second, 0},
+		{10 * time.Second, true, 2, 1 * time.Second, 0},
+		{10500 * time.Millisecond, true, 1, 1500 * time.Millisecond, 0},
+	}
+
+	var tat time.Time
+	for i, step := range steps {
+		var got RateResult
+		got, tat = gcra(start.Add(step.at), tat, limit)
+		want := RateResult{
+			Allowed:    step.allowed,
+			Limit:      limit.Burst,
+			Remaining:  step.remaining,
+			ResetAfter: step.resetAfter,
+			RetryAfter: step.retryAfter,
+		}
+		if got != want {
+			t.Errorf("step %d at %v: gcra = %+v, want %+v", i, step.at, got, want)
+		}
+	}
+}
@@ -2,16 +2,21 @@ package utils
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-var redisClient *redis.Client
-
-func InitRedis() error {
+// NewRedisClient builds a Redis client from REDIS_HOST, REDIS_PORT,
+// REDIS_USERNAME, REDIS_PASSWORD, REDIS_DB and REDIS_TLS, and checks that
+// the server answers. The client is returned even when the ping fails since
+// go-redis reconnects on its own.
+func NewRedisClient() (*redis.Client, error) {
 	redisHost := os.Getenv("REDIS_HOST")
 	redisPort := os.Getenv("REDIS_PORT")
 	if redisHost == "" {
@@ -21,46 +26,101 @@ func InitRedis() error {
 		redisPort = "6379"
 	}
 
-	redisClient = redis.NewClient(&redis.Options{
+	opts := &redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", redisHost, redisPort),
-		Password: "", // no password set
-		DB:       0,  // use default DB
-	})
+		Username: os.Getenv("REDIS_USERNAME"),
+		Password: os.Getenv("REDIS_PASSWORD"),
+		DB:       int(EnvInt64("REDIS_DB", 0)),
+	}
+	if EnvBool("REDIS_TLS", false) {
+		opts.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         redisHost,
+			InsecureSkipVerify: EnvBool("REDIS_TLS_INSECURE", false),
+		}
+	}
+	client := redis.NewClient(opts)
 
 	// Test the connection
-	ctx := context.Background()
-	_, err := redisClient.Ping(ctx).Result()
-	return err
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return client, client.Ping(ctx).Err()
 }
 
-// GetCache retrieves a string value from Redis cache
-func GetCache(key string) (string, error) {
-	ctx := context.Background()
-	return redisClient.Get(ctx, key).Result()
+// RedisCache is a Cache shared by every instance through Redis
+type RedisCache struct {
+	client *redis.Client
 }
 
-// SetCache sets a string value in Redis cache with expiration
-func SetCache(key string, value string, expiration time.Duration) error {
-	ctx := context.Background()
-	return redisClient.Set(ctx, key, value, expiration).Err()
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
 }
 
-func DeleteCache(key string) error {
-	ctx := context.Background()
-	return redisClient.Del(ctx, key).Err()
+// Get retrieves a string value, or ErrCacheMiss
+func (r *RedisCache) Get(ctx context.Context, key string) (string, error) {
+	value, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrCacheMiss
+	}
+	return value, err
+}
+
+// Set stores a string value; a zero ttl keeps it until deleted
+func (r *RedisCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
 }
 
-func ClearUserCache(userID uint) error {
-	ctx := context.Background()
-	pattern := "user:*"
-	iter := redisClient.Scan(ctx, 0, pattern, 0).Iterator()
+func (r *RedisCache) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, key).Err()
+}
 
+// DeleteByPrefix removes every key starting with prefix. It scans in
+// batches so large keyspaces don't block Redis.
+func (r *RedisCache) DeleteByPrefix(ctx context.Context, prefix string) error {
+	iter := r.client.Scan(ctx, 0, escapeRedisPattern(prefix)+"*", 500).Iterator()
+	var batch []string
 	for iter.Next(ctx) {
-		key := iter.Val()
-		if err := redisClient.Del(ctx, key).Err(); err != nil {
-			return err
+		batch = append(batch, iter.Val())
+		if len(batch) == 500 {
+			if err := r.client.Unlink(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
 		}
 	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return r.client.Unlink(ctx, batch...).Err()
+	}
+	return nil
+}
+
+// incrScript sets the expiry only when the counter is created, so a
+// window-based counter is not extended by every hit
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n`)
 
-	return iter.Err()
+// Incr adds one to a counter and returns the new value. A new counter
+// expires after ttl; zero keeps it until deleted.
+func (r *RedisCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, r.client, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+// escapeRedisPattern quotes the glob characters SCAN MATCH understands
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
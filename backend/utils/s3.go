@@ -2,16 +2,20 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"time"
 
+	"filesharing/models"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Client struct {
@@ -46,13 +50,30 @@ func NewS3Client() (*S3Client, error) {
 	}, nil
 }
 
-func (s *S3Client) UploadFile(ctx context.Context, file io.Reader, filename string, contentType string) (string, error) {
-	// Generate a unique key for the file
-	key := fmt.Sprintf("uploads/%s/%s", time.Now().Format("2006/01/02"), filename)
+// ObjectKey returns the S3 key of a file uploaded at createdAt
+func ObjectKey(createdAt time.Time, filename string) string {
+	return fmt.Sprintf("uploads/%s/%s", createdAt.Format("2006/01/02"), filename)
+}
+
+// FileObjectKey returns the S3 key of a file's content
+func FileObjectKey(file *models.File) string {
+	if file.ObjectKey != "" {
+		return file.ObjectKey
+	}
+	return ObjectKey(file.CreatedAt, file.Filename)
+}
 
+// ThumbnailKey returns the S3 key of a file's thumbnail of the given size
+func ThumbnailKey(fileID uint, size string) string {
+	return fmt.Sprintf("thumbnails/%d/%s", fileID, size)
+}
+
+// UploadFile stores file under key, unless file is nil, and returns a
+// presigned URL for it
+func (s *S3Client) UploadFile(ctx context.Context, file io.Reader, key string, contentType string, sums *Checksums) (string, error) {
 	// If no content type provided, try to determine it from the file extension
 	if contentType == "" {
-		ext := filepath.Ext(filename)
+		ext := filepath.Ext(key)
 		switch ext {
 		case ".pdf":
 			contentType = "application/pdf"
@@ -73,14 +94,8 @@ func (s *S3Client) UploadFile(ctx context.Context, file io.Reader, filename stri
 
 	// If file is provided, upload it
 	if file != nil {
-		_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket:      aws.String(s.Bucket),
-			Key:         aws.String(key),
-			Body:        file,
-			ContentType: aws.String(contentType),
-		})
-		if err != nil {
-			return "", fmt.Errorf("failed to upload file to S3: %v", err)
+		if err := s.PutObject(ctx, key, file, contentType, sums); err != nil {
+			return "", err
 		}
 	}
 
@@ -99,8 +114,8 @@ func (s *S3Client) UploadFile(ctx context.Context, file io.Reader, filename stri
 	return presignResult.URL, nil
 }
 
-func (s *S3Client) DeleteFile(ctx context.Context, filename string) error {
-	key := fmt.Sprintf("uploads/%s/%s", time.Now().Format("2006/01/02"), filename)
+// DeleteObject removes the object stored under key
+func (s *S3Client) DeleteObject(ctx context.Context, key string) error {
 	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
@@ -118,3 +133,91 @@ func (s *S3Client) HeadObject(ctx context.Context, key string) error {
 	})
 	return err
 }
+
+// PutObject stores body under key. When sums is set S3 verifies the payload
+// against the checksums computed locally.
+func (s *S3Client) PutObject(ctx context.Context, key string, body io.Reader, contentType string, sums *Checksums) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	}
+	if sums != nil {
+		input.ContentMD5 = aws.String(sums.MD5Base64())
+		input.ChecksumSHA256 = aws.String(sums.SHA256Base64())
+	}
+
+	if _, err := s.Client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload file to S3: %v", err)
+	}
+	return nil
+}
+
+// GetObject opens the object stored under key for reading
+func (s *S3Client) GetObject(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file from S3: %w", err)
+	}
+	return out, nil
+}
+
+// GetObjectRange opens length bytes of the object under key starting at offset
+func (s *S3Client) GetObjectRange(ctx context.Context, key string, offset, length int64) (*s3.GetObjectOutput, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file from S3: %w", err)
+	}
+	return out, nil
+}
+
+// IsNoSuchKey reports whether err means the object does not exist, as
+// opposed to S3 being unreachable or slow. HeadObject has no body to carry
+// NoSuchKey and reports a missing object as NotFound instead.
+func IsNoSuchKey(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// ObjectInfo describes an object returned by ListObjects
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects calls fn for every object stored under prefix
+func (s *S3Client) ListObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list files in S3: %v", err)
+		}
+		for _, obj := range page.Contents {
+			info := ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// scanChunkSize is the size of the chunks streamed to clamd
+const scanChunkSize = 64 << 10
+
+// ErrScanTooLarge is returned for files over SCAN_MAX_SIZE, which clamd
+// would reject
+var ErrScanTooLarge = errors.New("file is too large to scan")
+
+// ScanEnabled reports whether uploads are scanned for malware, which needs
+// a clamd daemon at CLAMAV_ADDR ("host:port" or a unix socket path)
+func ScanEnabled() bool {
+	return os.Getenv("CLAMAV_ADDR") != ""
+}
+
+// ScanMaxSize is the largest file sent to clamd; keep it at or below clamd's
+// StreamMaxLength (default 25MB)
+func ScanMaxSize() int64 {
+	return EnvSize("SCAN_MAX_SIZE", 25<<20)
+}
+
+// ScanStream sends r to clamd with INSTREAM and returns the name of the
+// signature it matched, or "" when the content is clean
+func ScanStream(ctx context.Context, r io.Reader) (string, error) {
+	addr := os.Getenv("CLAMAV_ADDR")
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(EnvDuration("SCAN_TIMEOUT", 2*time.Minute))
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, scanChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := conn.Write(append(size, buf[:n]...)); werr != nil {
+				return "", werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	// The reply is "stream: OK", "stream: <signature> FOUND" or "<message> ERROR"
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil {
+		return "", err
+	}
+	result := strings.TrimPrefix(string(bytes.TrimRight(reply, "\x00\n")), "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", result)
+	}
+}
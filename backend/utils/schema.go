@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// MakeAppendOnly installs a trigger that rejects updates and deletes on a
+// table, so rows can only ever be added
+func MakeAppendOnly(db *gorm.DB, table string) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	fn := table + "_append_only"
+	statements := []string{
+		fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION '%s is append-only';
+			END
+			$$ LANGUAGE plpgsql`, fn, table),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %s ON %s`, fn, table),
+		fmt.Sprintf(`CREATE TRIGGER %s BEFORE UPDATE OR DELETE OR TRUNCATE ON %s
+			FOR EACH STATEMENT EXECUTE FUNCTION %s()`, fn, table, fn),
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+)
+
+// visitorKey keys the visitor hash. Without ANALYTICS_SALT it is derived
+// from the JWT secret so hashes stay stable across restarts.
+func visitorKey() []byte {
+	if salt := os.Getenv("ANALYTICS_SALT"); salt != "" {
+		return []byte(salt)
+	}
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("share-analytics"))
+	return mac.Sum(nil)
+}
+
+// HashVisitor turns an IP address into an opaque identifier, so unique
+// visitors can be counted without storing addresses
+func HashVisitor(ip string) string {
+	mac := hmac.New(sha256.New, visitorKey())
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// HashShareLink identifies a share link without storing its token
+func HashShareLink(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}